@@ -3,11 +3,14 @@ package main
 import (
 	"log"
 	"net/http"
+	_ "time/tzdata"
 
+	"github.com/capybara120404/todo-list/internal/auth"
 	"github.com/capybara120404/todo-list/internal/configs"
 	"github.com/capybara120404/todo-list/internal/database"
 	"github.com/capybara120404/todo-list/internal/handlers"
 	"github.com/capybara120404/todo-list/internal/repository"
+	"github.com/capybara120404/todo-list/internal/utils"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -19,21 +22,91 @@ func main() {
 	}
 	defer connecter.Close()
 
-	repository := repository.NewTaskRepository(connecter)
-	handlers := handlers.NewTaskHandler(repository)
+	if configs.PathToHolidays != "" {
+		err = utils.LoadHolidays(configs.PathToHolidays)
+		if err != nil {
+			log.Printf("%v", err)
+			return
+		}
+	}
+
+	key, err := auth.LoadKey(configs.PathToKey)
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+
+	taskRepository := repository.NewTaskRepository(connecter)
+	listRepository := repository.NewListRepository(connecter)
+	userRepository := repository.NewUserRepository(connecter)
+	apiTokenRepository := repository.NewAPITokenRepository(connecter)
+	totpRepository := repository.NewTOTPRepository(connecter)
+	authenticator := auth.NewAuthenticator(key, configs.Password, userRepository, apiTokenRepository, totpRepository)
+
+	authHandlers := handlers.NewAuthHandler(authenticator, userRepository, configs.Password)
+
+	var oidc *auth.OIDC
+	if configs.OIDCIssuer != "" {
+		oidc, err = auth.NewOIDC(configs.OIDCIssuer, configs.OIDCClientId, configs.OIDCClientSecret, configs.OIDCRedirectURL)
+		if err != nil {
+			log.Printf("%v", err)
+			return
+		}
+
+		// The first user may come from the identity provider, so the
+		// server mustn't be open until then.
+		authenticator.RequireSignIn()
+	}
+
+	oidcHandlers := handlers.NewOIDCHandler(oidc, authenticator, userRepository, configs.Password)
+	apiTokenHandlers := handlers.NewAPITokenHandler(apiTokenRepository)
+	totpHandlers := handlers.NewTOTPHandler(totpRepository)
+	listHandlers := handlers.NewListHandler(listRepository, taskRepository)
+	handlers := handlers.NewTaskHandler(taskRepository)
 
 	router := chi.NewRouter()
 
 	fs := http.FileServer(http.Dir("web"))
 
 	router.Handle("/*", http.StripPrefix("/", fs))
+	router.Post("/api/signin", authHandlers.SignInHandler)
 	router.Get("/api/nextdate", handlers.NexDateHandler)
-	router.Get("/api/tasks", handlers.GetAllTasksHandler)
-	router.Get("/api/task", handlers.GetTaskByIdHandler)
-	router.Post("/api/task", handlers.AddTaskHandler)
-	router.Post("/api/task/done", handlers.CompleteTaskHandler)
-	router.Put("/api/task", handlers.ChangeTaskHandler)
-	router.Delete("/api/task", handlers.DeleteTaskHandler)
+	if oidc != nil {
+		router.Get("/api/oidc/login", oidcHandlers.OIDCLoginHandler)
+		router.Get("/api/oidc/callback", oidcHandlers.OIDCCallbackHandler)
+	}
+
+	router.Group(func(router chi.Router) {
+		router.Use(authenticator.Middleware)
+		router.Group(func(router chi.Router) {
+			router.Use(auth.RequireScope(auth.ScopeAdmin))
+			router.Post("/api/register", authHandlers.RegisterHandler)
+			router.Get("/api/tokens", apiTokenHandlers.GetAllTokensHandler)
+			router.Post("/api/tokens", apiTokenHandlers.CreateTokenHandler)
+			router.Delete("/api/tokens/{id}", apiTokenHandlers.RevokeTokenHandler)
+			router.Get("/api/totp", totpHandlers.GetTOTPHandler)
+			router.Post("/api/totp", totpHandlers.EnrolTOTPHandler)
+			router.Post("/api/totp/confirm", totpHandlers.ConfirmTOTPHandler)
+			router.Post("/api/totp/recovery", totpHandlers.RecoveryCodesHandler)
+			router.Delete("/api/totp", totpHandlers.DisableTOTPHandler)
+		})
+
+		router.Get("/api/occurrences", handlers.OccurrencesHandler)
+		router.Get("/api/describe", handlers.DescribeRepeatHandler)
+		router.Get("/api/repeat/parse", handlers.ParseRepeatHandler)
+		router.Get("/api/tasks", handlers.GetAllTasksHandler)
+		router.Get("/api/agenda", handlers.AgendaHandler)
+		router.Get("/api/task", handlers.GetTaskByIdHandler)
+		router.Post("/api/task", handlers.AddTaskHandler)
+		router.Post("/api/task/done", handlers.CompleteTaskHandler)
+		router.Post("/api/task/skip", handlers.SkipTaskHandler)
+		router.Put("/api/task", handlers.ChangeTaskHandler)
+		router.Delete("/api/task", handlers.DeleteTaskHandler)
+		router.Get("/api/lists", listHandlers.GetAllListsHandler)
+		router.Post("/api/lists", listHandlers.SaveListHandler)
+		router.Delete("/api/lists/{name}", listHandlers.DeleteListHandler)
+		router.Get("/api/lists/{name}/tasks", listHandlers.GetListTasksHandler)
+	})
 
 	log.Printf("The server start at port: %s", configs.Addr)
 
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeTokens keeps the owner and scope of tokens by their hash.
+type fakeTokens map[string]principal
+
+func (tokens fakeTokens) Lookup(hash string) (int64, string, error) {
+	token, ok := tokens[hash]
+	if !ok {
+		return 0, "", fmt.Errorf("token not found")
+	}
+
+	return token.id, token.scope, nil
+}
+
+func TestNewAPIToken(t *testing.T) {
+	token, hash, err := NewAPIToken()
+	if err != nil {
+		t.Fatalf("NewAPIToken returned %v", err)
+	}
+
+	if !strings.HasPrefix(token, APITokenPrefix) || strings.Contains(hash, token) || hash != HashAPIToken(token) {
+		t.Errorf("NewAPIToken returned %q, %q", token, hash)
+	}
+
+	other, _, err := NewAPIToken()
+	if err != nil || other == token {
+		t.Errorf("NewAPIToken returned %q twice", token)
+	}
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	tokens := fakeTokens{}
+	secrets := map[string]string{}
+	for _, scope := range []string{ScopeRead, ScopeWrite, ScopeAdmin} {
+		token, hash, err := NewAPIToken()
+		if err != nil {
+			t.Fatalf("NewAPIToken returned %v", err)
+		}
+
+		tokens[hash] = principal{id: 3, scope: scope}
+		secrets[scope] = token
+	}
+
+	authenticator := NewAuthenticator(testKey, "secret", nil, tokens, nil)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if UserId(r.Context()) != 3 {
+			t.Errorf("the request was let through for user %d", UserId(r.Context()))
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := authenticator.Middleware(ok)
+	admin := authenticator.Middleware(RequireScope(ScopeAdmin)(ok))
+
+	tbl := []struct {
+		handler http.Handler
+		method  string
+		header  string
+		status  int
+	}{
+		{handler, http.MethodGet, "Bearer " + secrets[ScopeRead], http.StatusOK},
+		{handler, http.MethodPost, "Bearer " + secrets[ScopeRead], http.StatusForbidden},
+		{handler, http.MethodDelete, "bearer " + secrets[ScopeWrite], http.StatusOK},
+		{handler, http.MethodGet, "Bearer todo_revoked", http.StatusUnauthorized},
+		{handler, http.MethodGet, "Basic " + secrets[ScopeAdmin], http.StatusUnauthorized},
+		{admin, http.MethodGet, "Bearer " + secrets[ScopeWrite], http.StatusForbidden},
+		{admin, http.MethodPost, "Bearer " + secrets[ScopeAdmin], http.StatusOK},
+	}
+	for i, v := range tbl {
+		request := httptest.NewRequest(v.method, "/api/tasks", nil)
+		request.Header.Set("Authorization", v.header)
+
+		recorder := httptest.NewRecorder()
+		v.handler.ServeHTTP(recorder, request)
+		if recorder.Code != v.status {
+			t.Errorf("case %d: status %d, want %d", i, recorder.Code, v.status)
+		}
+	}
+}
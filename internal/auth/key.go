@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// keySize is the size of the key tokens are signed with, as long as a
+// SHA-256 hash.
+const keySize = 32
+
+// LoadKey reads the key tokens are signed with from a file, creating the
+// file with a random key on the first start. The key is kept out of the
+// database, so that reading the database isn't enough to forge tokens.
+func LoadKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) < keySize {
+			return nil, fmt.Errorf("the key in %s is too short", path)
+		}
+
+		return key, nil
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error reading the key: %v", err)
+	}
+
+	key = make([]byte, keySize)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the key")
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the key: %v", err)
+	}
+
+	_, err = file.Write(key)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("error writing the key: %v", err)
+	}
+
+	return key, nil
+}
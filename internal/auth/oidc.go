@@ -0,0 +1,564 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// OIDCCookieName is the cookie that keeps the state, nonce and PKCE
+	// verifier of a sign-in between the redirect to the identity provider
+	// and the callback.
+	OIDCCookieName = "oidc"
+	// OIDCLoginLifetime is how long a sign-in at the provider may take.
+	OIDCLoginLifetime = 10 * time.Minute
+
+	// jwksLifetime is how long the signing keys of the provider are cached.
+	jwksLifetime = time.Hour
+	// jwksRefreshInterval is how soon the keys may be fetched again when an
+	// ID token is signed with an unknown key, as after a key rotation.
+	jwksRefreshInterval = time.Minute
+	// clockSkew is how far the clocks of the provider and the server may
+	// disagree about when an ID token was issued and expires.
+	clockSkew = time.Minute
+	// maxOIDCResponse limits what is read from the provider.
+	maxOIDCResponse = 1 << 20
+)
+
+// Identity is who an identity provider signed in.
+type Identity struct {
+	Issuer  string
+	Subject string
+	// Username and Email are what the user is called at the provider, to
+	// pick a login from the first time. Either may be empty.
+	Username string
+	Email    string
+}
+
+// OIDCLogin is what a sign-in with OpenID Connect keeps between the redirect
+// to the identity provider and the callback.
+type OIDCLogin struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// OIDC signs users in with the authorization code flow of OpenID Connect,
+// with PKCE. The endpoints of the identity provider are discovered from its
+// issuer URL, and its signing keys are cached.
+type OIDC struct {
+	issuer       string
+	clientId     string
+	clientSecret string
+	redirectURL  string
+	client       *http.Client
+
+	mutex       sync.Mutex
+	provider    *oidcProvider
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+// oidcProvider is the part of the discovery document that is used.
+type oidcProvider struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type idTokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type idTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	Expires           int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	PreferredUsername string   `json:"preferred_username"`
+	Email             string   `json:"email"`
+}
+
+// audience is the aud claim, which is a string or an array of strings.
+type audience []string
+
+func (aud *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if json.Unmarshal(data, &one) == nil {
+		*aud = audience{one}
+		return nil
+	}
+
+	var many []string
+	err := json.Unmarshal(data, &many)
+	if err != nil {
+		return fmt.Errorf("invalid audience")
+	}
+
+	*aud = many
+
+	return nil
+}
+
+// NewOIDC takes the issuer URL of the identity provider, the client the
+// server is registered as and the URL the provider sends users back to.
+// The secret may be empty for a public client. The issuer and the
+// endpoints it names must use HTTPS, except on the loopback interface.
+func NewOIDC(issuer, clientId, clientSecret, redirectURL string) (*OIDC, error) {
+	if clientId == "" {
+		return nil, fmt.Errorf("the OpenID Connect client Id is not set")
+	}
+
+	err := checkOIDCURL(issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid issuer: %v", err)
+	}
+
+	redirect, err := url.Parse(redirectURL)
+	if err != nil || !redirect.IsAbs() {
+		return nil, fmt.Errorf("invalid redirect URL %q", redirectURL)
+	}
+
+	return &OIDC{
+		issuer:       issuer,
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// checkOIDCURL makes sure the provider is reached over HTTPS, or over plain
+// HTTP on the loopback interface, as a stand-in provider in tests is.
+func checkOIDCURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || !parsed.IsAbs() || parsed.Host == "" {
+		return fmt.Errorf("%q is not an absolute URL", rawURL)
+	}
+
+	if parsed.Scheme == "https" {
+		return nil
+	}
+
+	host := parsed.Hostname()
+	ip := net.ParseIP(host)
+	if parsed.Scheme == "http" && (host == "localhost" || ip != nil && ip.IsLoopback()) {
+		return nil
+	}
+
+	return fmt.Errorf("%q does not use HTTPS", rawURL)
+}
+
+// NewOIDCLogin starts a sign-in with random state, nonce and PKCE verifier.
+func NewOIDCLogin() (OIDCLogin, error) {
+	values := make([]string, 3)
+	for i := range values {
+		data := make([]byte, 32)
+		_, err := rand.Read(data)
+		if err != nil {
+			return OIDCLogin{}, fmt.Errorf("error starting the sign-in")
+		}
+
+		values[i] = base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	return OIDCLogin{State: values[0], Nonce: values[1], Verifier: values[2]}, nil
+}
+
+// ParseOIDCLogin reads a sign-in back from its String.
+func ParseOIDCLogin(value string) (OIDCLogin, error) {
+	values := strings.Split(value, ".")
+	if len(values) != 3 || slices.Contains(values, "") {
+		return OIDCLogin{}, fmt.Errorf("invalid sign-in state")
+	}
+
+	return OIDCLogin{State: values[0], Nonce: values[1], Verifier: values[2]}, nil
+}
+
+// String is how a sign-in is kept in the OIDC cookie.
+func (login OIDCLogin) String() string {
+	return login.State + "." + login.Nonce + "." + login.Verifier
+}
+
+// CheckState reports whether the state the provider sent back is the one
+// of the sign-in.
+func (login OIDCLogin) CheckState(state string) bool {
+	return subtle.ConstantTimeCompare([]byte(state), []byte(login.State)) == 1
+}
+
+// AuthCodeURL returns the URL of the provider to send the user to.
+func (oidc *OIDC) AuthCodeURL(ctx context.Context, login OIDCLogin) (string, error) {
+	provider, err := oidc.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {oidc.clientId},
+		"redirect_uri":          {oidc.redirectURL},
+		"scope":                 {"openid profile email"},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return provider.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems the code the provider sent back and returns who the ID
+// token in the answer is for, once it is checked.
+func (oidc *OIDC) Exchange(ctx context.Context, code string, login OIDCLogin) (Identity, error) {
+	if code == "" {
+		return Identity{}, fmt.Errorf("the code is missing")
+	}
+
+	provider, err := oidc.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oidc.redirectURL},
+		"client_id":     {oidc.clientId},
+		"code_verifier": {login.Verifier},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, fmt.Errorf("error requesting the ID token")
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if oidc.clientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(oidc.clientId), url.QueryEscape(oidc.clientSecret))
+	}
+
+	var answer struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := oidc.do(request, &answer)
+	if err != nil {
+		return Identity{}, fmt.Errorf("error requesting the ID token: %v", err)
+	}
+
+	if answer.Error != "" {
+		return Identity{}, fmt.Errorf("the identity provider refused the code: %s %s", answer.Error, answer.ErrorDescription)
+	}
+
+	if status != http.StatusOK || answer.IdToken == "" {
+		return Identity{}, fmt.Errorf("the identity provider sent no ID token")
+	}
+
+	idClaims, err := oidc.verifyIdToken(ctx, answer.IdToken, login.Nonce)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return Identity{
+		Issuer:   idClaims.Issuer,
+		Subject:  idClaims.Subject,
+		Username: idClaims.PreferredUsername,
+		Email:    idClaims.Email,
+	}, nil
+}
+
+// verifyIdToken checks the signature of an ID token with the keys of the
+// provider and that it was issued by the provider to this client for this
+// sign-in and hasn't expired.
+func (oidc *OIDC) verifyIdToken(ctx context.Context, token, nonce string) (idTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return idTokenClaims{}, fmt.Errorf("invalid ID token")
+	}
+
+	var header idTokenHeader
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return idTokenClaims{}, fmt.Errorf("invalid ID token")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return idTokenClaims{}, fmt.Errorf("invalid ID token")
+	}
+
+	key, err := oidc.key(ctx, header.Kid)
+	if err != nil {
+		return idTokenClaims{}, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return idTokenClaims{}, fmt.Errorf("invalid ID token signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(signature) != 64 {
+			return idTokenClaims{}, fmt.Errorf("invalid ID token signature")
+		}
+
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			return idTokenClaims{}, fmt.Errorf("invalid ID token signature")
+		}
+	default:
+		return idTokenClaims{}, fmt.Errorf("invalid ID token signature")
+	}
+
+	var idClaims idTokenClaims
+	err = decodeSegment(parts[1], &idClaims)
+	if err != nil {
+		return idTokenClaims{}, fmt.Errorf("invalid ID token")
+	}
+
+	now := time.Now()
+	switch {
+	case idClaims.Issuer != oidc.issuer:
+		return idTokenClaims{}, fmt.Errorf("the ID token is from another issuer")
+	case !slices.Contains(idClaims.Audience, oidc.clientId):
+		return idTokenClaims{}, fmt.Errorf("the ID token is for another client")
+	case len(idClaims.Audience) > 1 && idClaims.AuthorizedParty != oidc.clientId:
+		return idTokenClaims{}, fmt.Errorf("the ID token is for another client")
+	case now.Add(-clockSkew).Unix() >= idClaims.Expires:
+		return idTokenClaims{}, fmt.Errorf("the ID token has expired")
+	case now.Add(clockSkew).Unix() < idClaims.IssuedAt:
+		return idTokenClaims{}, fmt.Errorf("the ID token is issued in the future")
+	case subtle.ConstantTimeCompare([]byte(idClaims.Nonce), []byte(nonce)) != 1:
+		return idTokenClaims{}, fmt.Errorf("the ID token is for another sign-in")
+	case idClaims.Subject == "":
+		return idTokenClaims{}, fmt.Errorf("the ID token has no subject")
+	}
+
+	return idClaims, nil
+}
+
+// discover fetches the discovery document of the provider once it is
+// needed and keeps it.
+func (oidc *OIDC) discover(ctx context.Context) (*oidcProvider, error) {
+	oidc.mutex.Lock()
+	defer oidc.mutex.Unlock()
+
+	if oidc.provider != nil {
+		return oidc.provider, nil
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(oidc.issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error discovering the identity provider")
+	}
+
+	var provider oidcProvider
+	status, err := oidc.do(request, &provider)
+	if err != nil || status != http.StatusOK {
+		return nil, fmt.Errorf("error discovering the identity provider")
+	}
+
+	if provider.Issuer != oidc.issuer {
+		return nil, fmt.Errorf("the identity provider is for the issuer %q", provider.Issuer)
+	}
+
+	for _, endpoint := range []string{provider.AuthorizationEndpoint, provider.TokenEndpoint, provider.JWKSURI} {
+		err = checkOIDCURL(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid identity provider endpoint: %v", err)
+		}
+	}
+
+	if len(provider.CodeChallengeMethods) > 0 && !slices.Contains(provider.CodeChallengeMethods, "S256") {
+		return nil, fmt.Errorf("the identity provider doesn't support PKCE with S256")
+	}
+
+	oidc.provider = &provider
+
+	return oidc.provider, nil
+}
+
+// key returns a signing key of the provider by its Id. The keys are fetched
+// again when they are old, or when the Id is unknown and they weren't
+// fetched just now.
+func (oidc *OIDC) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	provider, err := oidc.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	oidc.mutex.Lock()
+	defer oidc.mutex.Unlock()
+
+	age := time.Since(oidc.keysFetched)
+	key, ok := oidc.keys[kid]
+	if ok && age < jwksLifetime {
+		return key, nil
+	}
+
+	if age >= jwksRefreshInterval {
+		keys, err := oidc.fetchKeys(ctx, provider.JWKSURI)
+		if err != nil {
+			return nil, err
+		}
+
+		oidc.keys = keys
+		oidc.keysFetched = time.Now()
+		key, ok = keys[kid]
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("the ID token is signed with an unknown key")
+	}
+
+	return key, nil
+}
+
+// fetchKeys returns the RSA and P-256 signing keys of a JWK set by their Id.
+func (oidc *OIDC) fetchKeys(ctx context.Context, jwksURI string) (map[string]crypto.PublicKey, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching the keys of the identity provider")
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	status, err := oidc.do(request, &set)
+	if err != nil || status != http.StatusOK {
+		return nil, fmt.Errorf("error fetching the keys of the identity provider")
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+
+		publicKey, err := key.publicKey()
+		if err != nil {
+			continue
+		}
+
+		keys[key.Kid] = publicKey
+	}
+
+	return keys, nil
+}
+
+func (key jwk) publicKey() (crypto.PublicKey, error) {
+	switch key.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key")
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid key")
+		}
+
+		publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if publicKey.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("the key is too short")
+		}
+
+		return publicKey, nil
+	case "EC":
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil || len(x) != 32 || key.Crv != "P-256" {
+			return nil, fmt.Errorf("invalid key")
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(key.Y)
+		if err != nil || len(y) != 32 {
+			return nil, fmt.Errorf("invalid key")
+		}
+
+		// ecdh checks that the point is on the curve.
+		_, err = ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key")
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", key.Kty)
+}
+
+// do sends a request to the provider and decodes the JSON answer.
+func (oidc *OIDC) do(request *http.Request, answer any) (int, error) {
+	response, err := oidc.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxOIDCResponse))
+	if err != nil {
+		return 0, err
+	}
+
+	err = json.Unmarshal(data, answer)
+	if err != nil {
+		return response.StatusCode, fmt.Errorf("invalid JSON from the identity provider")
+	}
+
+	return response.StatusCode, nil
+}
+
+func decodeSegment(segment string, value any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
@@ -0,0 +1,335 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIdP is a stand-in identity provider. It answers the code it hands out
+// with an ID token made by idToken, once the PKCE verifier matches.
+type fakeIdP struct {
+	server  *httptest.Server
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	kid     string
+	idToken func(claims map[string]any) map[string]any
+
+	mutex      sync.Mutex
+	challenges map[string]string
+	nonces     map[string]string
+	jwksHits   int
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey returned %v", err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey returned %v", err)
+	}
+
+	idp := &fakeIdP{rsaKey: rsaKey, ecKey: ecKey, kid: "rsa-1", challenges: map[string]string{}, nonces: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                           idp.server.URL,
+			"authorization_endpoint":           idp.server.URL + "/authorize",
+			"token_endpoint":                   idp.server.URL + "/token",
+			"jwks_uri":                         idp.server.URL + "/jwks",
+			"code_challenge_methods_supported": []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mutex.Lock()
+		idp.jwksHits++
+		kid := idp.kid
+		idp.mutex.Unlock()
+
+		encode := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]any{
+			{"kty": "RSA", "use": "sig", "kid": kid, "n": encode(rsaKey.N.Bytes()), "e": encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "use": "sig", "kid": "ec-1", "crv": "P-256", "x": encode(ecKey.X.FillBytes(make([]byte, 32))), "y": encode(ecKey.Y.FillBytes(make([]byte, 32)))},
+			{"kty": "RSA", "use": "enc", "kid": "enc-1", "n": encode(rsaKey.N.Bytes()), "e": "AQAB"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		// The credentials are form-encoded, as RFC 6749 says.
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "todo" || secret != url.QueryEscape("client secret") {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]any{"error": "invalid_client"})
+			return
+		}
+
+		code := r.PostFormValue("code")
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+
+		idp.mutex.Lock()
+		challenge, ok := idp.challenges[code]
+		nonce := idp.nonces[code]
+		delete(idp.challenges, code)
+		idp.mutex.Unlock()
+
+		if !ok || challenge != base64.RawURLEncoding.EncodeToString(verifier[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{"error": "invalid_grant"})
+			return
+		}
+
+		now := time.Now().Unix()
+		claims := map[string]any{"iss": idp.server.URL, "sub": "248289761001", "aud": "todo", "exp": now + 300, "iat": now,
+			"nonce": nonce, "preferred_username": "Anna.Petrova", "email": "anna@example.com"}
+		header := map[string]any{"alg": "RS256", "kid": idp.kid}
+		if idp.idToken != nil {
+			header = idp.idToken(claims)
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"id_token": idp.sign(t, header, claims), "token_type": "Bearer"})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+func (idp *fakeIdP) sign(t *testing.T, header, claims map[string]any) string {
+	encode := func(value any) string {
+		data, err := json.Marshal(value)
+		if err != nil {
+			t.Fatalf("Marshal returned %v", err)
+		}
+
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	payload := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(payload))
+	var signature []byte
+	switch header["alg"] {
+	case "RS256":
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, idp.rsaKey, crypto.SHA256, digest[:])
+	case "ES256":
+		r, s, _ := ecdsa.Sign(rand.Reader, idp.ecKey, digest[:])
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case "HS256":
+		mac := hmac.New(sha256.New, []byte(idp.server.URL))
+		mac.Write([]byte(payload))
+		signature = mac.Sum(nil)
+	}
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// authorize plays the user signing in at the provider and returns the code
+// it sends back.
+func (idp *fakeIdP) authorize(t *testing.T, oidc *OIDC, login OIDCLogin) string {
+	authURL, err := oidc.AuthCodeURL(context.Background(), login)
+	if err != nil {
+		t.Fatalf("AuthCodeURL returned %v", err)
+	}
+
+	parsed, err := url.Parse(authURL)
+	if err != nil || !strings.HasPrefix(authURL, idp.server.URL+"/authorize?") {
+		t.Fatalf("AuthCodeURL returned %q", authURL)
+	}
+
+	query := parsed.Query()
+	if query.Get("client_id") != "todo" || query.Get("state") != login.State || query.Get("code_challenge_method") != "S256" ||
+		!strings.Contains(query.Get("scope"), "openid") || query.Get("redirect_uri") != "http://localhost:7540/api/oidc/callback" {
+		t.Fatalf("AuthCodeURL returned %q", authURL)
+	}
+
+	code := base64.RawURLEncoding.EncodeToString(big.NewInt(time.Now().UnixNano()).Bytes())
+	idp.mutex.Lock()
+	idp.challenges[code] = query.Get("code_challenge")
+	idp.nonces[code] = query.Get("nonce")
+	idp.mutex.Unlock()
+
+	return code
+}
+
+func TestOIDC(t *testing.T) {
+	idp := newFakeIdP(t)
+	oidc, err := NewOIDC(idp.server.URL, "todo", "client secret", "http://localhost:7540/api/oidc/callback")
+	if err != nil {
+		t.Fatalf("NewOIDC returned %v", err)
+	}
+
+	login, err := NewOIDCLogin()
+	if err != nil {
+		t.Fatalf("NewOIDCLogin returned %v", err)
+	}
+
+	parsed, err := ParseOIDCLogin(login.String())
+	if err != nil || parsed != login || !parsed.CheckState(login.State) || parsed.CheckState(login.Nonce) {
+		t.Fatalf("ParseOIDCLogin returned %v, %v", parsed, err)
+	}
+
+	identity, err := oidc.Exchange(context.Background(), idp.authorize(t, oidc, login), login)
+	if err != nil {
+		t.Fatalf("Exchange returned %v", err)
+	}
+
+	if identity != (Identity{Issuer: idp.server.URL, Subject: "248289761001", Username: "Anna.Petrova", Email: "anna@example.com"}) {
+		t.Errorf("Exchange returned %v", identity)
+	}
+
+	// The code is single-use, and needs the verifier it was issued for.
+	code := idp.authorize(t, oidc, login)
+	other, _ := NewOIDCLogin()
+	_, err = oidc.Exchange(context.Background(), code, OIDCLogin{State: login.State, Nonce: login.Nonce, Verifier: other.Verifier})
+	if err == nil {
+		t.Errorf("Exchange accepted a wrong verifier")
+	}
+
+	_, err = oidc.Exchange(context.Background(), code, login)
+	if err == nil {
+		t.Errorf("Exchange accepted a used code")
+	}
+
+	tbl := []struct {
+		name    string
+		idToken func(claims map[string]any) map[string]any
+		ok      bool
+	}{
+		{"ES256", func(claims map[string]any) map[string]any {
+			return map[string]any{"alg": "ES256", "kid": "ec-1"}
+		}, true},
+		{"audience list", func(claims map[string]any) map[string]any {
+			claims["aud"] = []string{"todo", "calendar"}
+			claims["azp"] = "todo"
+			return map[string]any{"alg": "RS256", "kid": idp.kid}
+		}, true},
+		{"email", func(claims map[string]any) map[string]any {
+			delete(claims, "preferred_username")
+			return map[string]any{"alg": "RS256", "kid": idp.kid}
+		}, true},
+		{"none", func(claims map[string]any) map[string]any {
+			return map[string]any{"alg": "none", "kid": idp.kid}
+		}, false},
+		{"HS256", func(claims map[string]any) map[string]any {
+			return map[string]any{"alg": "HS256", "kid": idp.kid}
+		}, false},
+		{"wrong algorithm for the key", func(claims map[string]any) map[string]any {
+			return map[string]any{"alg": "ES256", "kid": idp.kid}
+		}, false},
+		{"encryption key", func(claims map[string]any) map[string]any {
+			return map[string]any{"alg": "RS256", "kid": "enc-1"}
+		}, false},
+		{"issuer", func(claims map[string]any) map[string]any {
+			claims["iss"] = "https://evil.example.com"
+			return map[string]any{"alg": "RS256", "kid": idp.kid}
+		}, false},
+		{"audience", func(claims map[string]any) map[string]any {
+			claims["aud"] = "calendar"
+			return map[string]any{"alg": "RS256", "kid": idp.kid}
+		}, false},
+		{"authorized party", func(claims map[string]any) map[string]any {
+			claims["aud"] = []string{"todo", "calendar"}
+			claims["azp"] = "calendar"
+			return map[string]any{"alg": "RS256", "kid": idp.kid}
+		}, false},
+		{"expired", func(claims map[string]any) map[string]any {
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			return map[string]any{"alg": "RS256", "kid": idp.kid}
+		}, false},
+		{"issued in the future", func(claims map[string]any) map[string]any {
+			claims["iat"] = time.Now().Add(time.Hour).Unix()
+			return map[string]any{"alg": "RS256", "kid": idp.kid}
+		}, false},
+		{"nonce", func(claims map[string]any) map[string]any {
+			claims["nonce"] = "replayed"
+			return map[string]any{"alg": "RS256", "kid": idp.kid}
+		}, false},
+		{"subject", func(claims map[string]any) map[string]any {
+			delete(claims, "sub")
+			return map[string]any{"alg": "RS256", "kid": idp.kid}
+		}, false},
+	}
+	for _, v := range tbl {
+		idp.idToken = v.idToken
+		_, err = oidc.Exchange(context.Background(), idp.authorize(t, oidc, login), login)
+		if (err == nil) != v.ok {
+			t.Errorf("%s: Exchange returned %v", v.name, err)
+		}
+	}
+
+	idp.idToken = nil
+	if idp.jwksHits != 1 {
+		t.Errorf("the keys were fetched %d times", idp.jwksHits)
+	}
+
+	// A rotated key is fetched, but not more than once a minute.
+	idp.kid = "rsa-2"
+	_, err = oidc.Exchange(context.Background(), idp.authorize(t, oidc, login), login)
+	if err == nil || idp.jwksHits != 1 {
+		t.Errorf("Exchange returned %v after fetching the keys %d times", err, idp.jwksHits)
+	}
+
+	oidc.keysFetched = time.Now().Add(-jwksRefreshInterval)
+	_, err = oidc.Exchange(context.Background(), idp.authorize(t, oidc, login), login)
+	if err != nil || idp.jwksHits != 2 {
+		t.Errorf("Exchange returned %v after fetching the keys %d times", err, idp.jwksHits)
+	}
+
+	wrongSecret, _ := NewOIDC(idp.server.URL, "todo", "wrong", "http://localhost:7540/api/oidc/callback")
+	_, err = wrongSecret.Exchange(context.Background(), idp.authorize(t, wrongSecret, login), login)
+	if err == nil {
+		t.Errorf("Exchange worked with a wrong client secret")
+	}
+
+	wrongIssuer, _ := NewOIDC(idp.server.URL+"/realms/other", "todo", "client secret", "http://localhost:7540/api/oidc/callback")
+	_, err = wrongIssuer.AuthCodeURL(context.Background(), login)
+	if err == nil {
+		t.Errorf("AuthCodeURL trusted the discovery document of another issuer")
+	}
+}
+
+func TestNewOIDC(t *testing.T) {
+	tbl := []struct {
+		issuer string
+		ok     bool
+	}{
+		{"https://sso.example.com/realms/company", true},
+		{"http://127.0.0.1:8080", true},
+		{"http://localhost:8080/", true},
+		{"http://sso.example.com", false},
+		{"sso.example.com", false},
+		{"", false},
+	}
+	for _, v := range tbl {
+		_, err := NewOIDC(v.issuer, "todo", "", "http://localhost:7540/api/oidc/callback")
+		if (err == nil) != v.ok {
+			t.Errorf("NewOIDC(%q) returned %v", v.issuer, err)
+		}
+	}
+
+	_, err := NewOIDC("https://sso.example.com", "", "", "http://localhost:7540/api/oidc/callback")
+	if err == nil {
+		t.Errorf("NewOIDC worked without a client Id")
+	}
+
+	_, err = NewOIDC("https://sso.example.com", "todo", "", "/api/oidc/callback")
+	if err == nil {
+		t.Errorf("NewOIDC worked with a relative redirect URL")
+	}
+}
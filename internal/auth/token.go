@@ -0,0 +1,325 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/capybara120404/todo-list/internal/utils"
+)
+
+// TokenLifetime is how long a token from SignIn lasts. The web interface
+// keeps its cookie for as long.
+const TokenLifetime = 8 * time.Hour
+
+// CookieName is the cookie the web interface sends the token in.
+const CookieName = "token"
+
+type contextKey struct{}
+
+// principal is who a request was let through for.
+type principal struct {
+	id    int64
+	scope string
+}
+
+// UserStore looks up the registered users.
+type UserStore interface {
+	// Any reports whether there are users.
+	Any() (bool, error)
+	// Check returns the Id and password hash of a user if the password is
+	// right.
+	Check(login, password string) (int64, string, error)
+	// PasswordHash returns the password hash of a user.
+	PasswordHash(id int64) (string, error)
+}
+
+// SecondFactorStore checks the second factor of users who turned it on.
+type SecondFactorStore interface {
+	// CheckSecondFactor returns nil if a user has no second factor, or if
+	// the code is their TOTP code or one of their unused recovery codes,
+	// which it uses up.
+	CheckSecondFactor(id int64, code string) error
+}
+
+// Authenticator signs users in and checks their tokens. Registered users
+// sign in with their login and password. The password from TODO_PASSWORD
+// signs in without a login, as user 0, who owns the tasks from before there
+// were users. Tokens are signed with the key of the server and carry a
+// fingerprint of the password, or of the password hash of a user, so
+// changing the password invalidates them. Users who turned on a second
+// factor also need its code. Without a password or users there is nothing
+// to sign in to and every request is let through as user 0.
+type Authenticator struct {
+	key      []byte
+	password string
+	users    UserStore
+	tokens   TokenStore
+	factors  SecondFactorStore
+	// required makes requests need a token even before there are users.
+	required bool
+}
+
+type claims struct {
+	Subject  int64  `json:"sub"`
+	Expires  int64  `json:"exp"`
+	Password string `json:"pwd"`
+}
+
+// NewAuthenticator takes the key to sign tokens with, see LoadKey, the
+// password from TODO_PASSWORD, which may be empty, and the users, API
+// tokens and second factors, which may be nil.
+func NewAuthenticator(key []byte, password string, users UserStore, tokens TokenStore, factors SecondFactorStore) *Authenticator {
+	return &Authenticator{
+		key:      key,
+		password: password,
+		users:    users,
+		tokens:   tokens,
+		factors:  factors,
+	}
+}
+
+// RequireSignIn makes requests need a token even before there are users,
+// for when the first user signs in elsewhere, such as with OpenID Connect.
+func (authenticator *Authenticator) RequireSignIn() {
+	authenticator.required = true
+}
+
+// Enabled reports whether requests need a token. It fails closed: when the
+// users can't be looked up, they do.
+func (authenticator *Authenticator) Enabled() bool {
+	if authenticator.password != "" || authenticator.required {
+		return true
+	}
+
+	if authenticator.users == nil {
+		return false
+	}
+
+	exists, err := authenticator.users.Any()
+
+	return err != nil || exists
+}
+
+// UserId returns the Id of the user a request was let through for.
+func UserId(ctx context.Context) int64 {
+	user, _ := ctx.Value(contextKey{}).(principal)
+
+	return user.id
+}
+
+func withPrincipal(r *http.Request, id int64, scope string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), contextKey{}, principal{id: id, scope: scope}))
+}
+
+// SignIn checks a login and password, or the password from TODO_PASSWORD
+// when the login is empty, and the code of the second factor if the user
+// turned it on, and returns a token for the user.
+func (authenticator *Authenticator) SignIn(login, password, code string) (string, error) {
+	if login != "" {
+		if authenticator.users == nil {
+			return "", fmt.Errorf("wrong login or password")
+		}
+
+		id, hash, err := authenticator.users.Check(login, password)
+		if err != nil {
+			return "", err
+		}
+
+		err = authenticator.checkSecondFactor(id, code)
+		if err != nil {
+			return "", err
+		}
+
+		return authenticator.sign(claims{Subject: id, Expires: time.Now().Add(TokenLifetime).Unix()}, hash)
+	}
+
+	if authenticator.password == "" {
+		if authenticator.Enabled() {
+			return "", fmt.Errorf("the login field should not be empty")
+		}
+
+		return "", fmt.Errorf("sign-in is disabled, no password is set")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(password), []byte(authenticator.password)) != 1 {
+		return "", fmt.Errorf("wrong password")
+	}
+
+	err := authenticator.checkSecondFactor(0, code)
+	if err != nil {
+		return "", err
+	}
+
+	return authenticator.sign(claims{Expires: time.Now().Add(TokenLifetime).Unix()}, authenticator.password)
+}
+
+// checkSecondFactor checks the code of the second factor of a user who got
+// the password right.
+func (authenticator *Authenticator) checkSecondFactor(id int64, code string) error {
+	if authenticator.factors == nil {
+		return nil
+	}
+
+	return authenticator.factors.CheckSecondFactor(id, code)
+}
+
+// Issue returns a token for a user who was signed in some other way, such
+// as by an identity provider.
+func (authenticator *Authenticator) Issue(id int64) (string, error) {
+	if authenticator.users == nil {
+		return "", fmt.Errorf("user not found")
+	}
+
+	hash, err := authenticator.users.PasswordHash(id)
+	if err != nil {
+		return "", err
+	}
+
+	return authenticator.sign(claims{Subject: id, Expires: time.Now().Add(TokenLifetime).Unix()}, hash)
+}
+
+// Verify checks that a token was signed by the server for the current
+// password of its user and hasn't expired, and returns the Id of the user.
+func (authenticator *Authenticator) Verify(token string) (int64, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, fmt.Errorf("invalid token")
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, authenticator.mac(payload)) {
+		return 0, fmt.Errorf("invalid token")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return 0, fmt.Errorf("invalid token")
+	}
+
+	var tokenClaims claims
+	err = json.Unmarshal(data, &tokenClaims)
+	if err != nil {
+		return 0, fmt.Errorf("invalid token")
+	}
+
+	secret := authenticator.password
+	if tokenClaims.Subject != 0 {
+		if authenticator.users == nil {
+			return 0, fmt.Errorf("invalid token")
+		}
+
+		secret, err = authenticator.users.PasswordHash(tokenClaims.Subject)
+		if err != nil {
+			return 0, fmt.Errorf("invalid token")
+		}
+	}
+
+	if secret == "" || !hmac.Equal([]byte(tokenClaims.Password), []byte(authenticator.fingerprint(secret))) {
+		return 0, fmt.Errorf("invalid token")
+	}
+
+	if time.Now().Unix() >= tokenClaims.Expires {
+		return 0, fmt.Errorf("the token has expired")
+	}
+
+	return tokenClaims.Subject, nil
+}
+
+// Middleware lets through requests with a valid token, with the user and
+// the scope of the token in their context, and answers the others with 401
+// Unauthorized. The token is taken from an "Authorization: Bearer" header
+// or else from the token cookie. Tokens of the read scope can only make GET
+// requests.
+func (authenticator *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authenticator.Enabled() {
+			next.ServeHTTP(w, withPrincipal(r, 0, ScopeAdmin))
+			return
+		}
+
+		token := bearer(r)
+		if token == "" {
+			cookie, err := r.Cookie(CookieName)
+			if err == nil {
+				token = cookie.Value
+			}
+		}
+
+		if token == "" {
+			utils.WriteJSONError(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		id, scope, err := authenticator.check(token)
+		if err != nil {
+			utils.WriteJSONError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		if !allows(scope, ScopeWrite) && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			utils.WriteJSONError(w, "the token is read-only", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, withPrincipal(r, id, scope))
+	})
+}
+
+// check returns the user and scope of an API token or of a token from
+// signing in, which has every scope.
+func (authenticator *Authenticator) check(token string) (int64, string, error) {
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		id, err := authenticator.Verify(token)
+
+		return id, ScopeAdmin, err
+	}
+
+	if authenticator.tokens == nil {
+		return 0, "", fmt.Errorf("invalid token")
+	}
+
+	id, scope, err := authenticator.tokens.Lookup(HashAPIToken(token))
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid token")
+	}
+
+	return id, scope, nil
+}
+
+// sign returns a token with the claims and the fingerprint of a password
+// or password hash.
+func (authenticator *Authenticator) sign(tokenClaims claims, secret string) (string, error) {
+	tokenClaims.Password = authenticator.fingerprint(secret)
+	data, err := json.Marshal(tokenClaims)
+	if err != nil {
+		return "", fmt.Errorf("error creating token")
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(authenticator.mac(payload)), nil
+}
+
+// fingerprint tells passwords apart without giving them away: without the
+// key of the server it can't be checked against guesses.
+func (authenticator *Authenticator) fingerprint(secret string) string {
+	mac := authenticator.mac("password\x00" + secret)
+
+	return base64.RawURLEncoding.EncodeToString(mac[:16])
+}
+
+// mac is the HMAC-SHA256 of a token payload with the key of the server.
+func (authenticator *Authenticator) mac(payload string) []byte {
+	hash := hmac.New(sha256.New, authenticator.key)
+	hash.Write([]byte(payload))
+
+	return hash.Sum(nil)
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testKey signs the tokens of the tests.
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+// fakeUsers keeps users as login, password and password hash.
+type fakeUsers map[int64][3]string
+
+func (users fakeUsers) Any() (bool, error) {
+	return len(users) > 0, nil
+}
+
+func (users fakeUsers) Check(login, password string) (int64, string, error) {
+	for id, user := range users {
+		if user[0] == login && user[1] == password {
+			return id, user[2], nil
+		}
+	}
+
+	return 0, "", fmt.Errorf("wrong login or password")
+}
+
+func (users fakeUsers) PasswordHash(id int64) (string, error) {
+	user, ok := users[id]
+	if !ok {
+		return "", fmt.Errorf("user not found")
+	}
+
+	return user[2], nil
+}
+
+func TestSignIn(t *testing.T) {
+	authenticator := NewAuthenticator(testKey, "secret", nil, nil, nil)
+
+	_, err := authenticator.SignIn("", "wrong", "")
+	if err == nil {
+		t.Errorf("SignIn accepted a wrong password")
+	}
+
+	token, err := authenticator.SignIn("", "secret", "")
+	if err != nil {
+		t.Fatalf("SignIn returned %v", err)
+	}
+
+	id, err := authenticator.Verify(token)
+	if err != nil || id != 0 {
+		t.Errorf("Verify returned %d, %v", id, err)
+	}
+
+	_, err = NewAuthenticator(testKey, "changed", nil, nil, nil).Verify(token)
+	if err == nil {
+		t.Errorf("a token outlived a change of the password")
+	}
+
+	// Knowing the password isn't enough to sign tokens.
+	_, err = NewAuthenticator([]byte("another key of another server.."), "secret", nil, nil, nil).Verify(token)
+	if err == nil {
+		t.Errorf("Verify accepted a token signed with another key")
+	}
+
+	expired, err := authenticator.sign(claims{Expires: time.Now().Add(-time.Minute).Unix()}, "secret")
+	if err != nil {
+		t.Fatalf("sign returned %v", err)
+	}
+
+	_, err = authenticator.Verify(expired)
+	if err == nil {
+		t.Errorf("Verify accepted an expired token")
+	}
+
+	for _, token := range []string{"", "abc", "abc.def", token + "x", "x" + token} {
+		_, err = authenticator.Verify(token)
+		if err == nil {
+			t.Errorf("Verify accepted %q", token)
+		}
+	}
+
+	_, err = NewAuthenticator(testKey, "", nil, nil, nil).SignIn("", "", "")
+	if err == nil {
+		t.Errorf("SignIn worked without a password")
+	}
+}
+
+func TestSignInUsers(t *testing.T) {
+	users := fakeUsers{1: {"anna", "anna's password", "hash 1"}, 2: {"boris", "boris's password", "hash 2"}}
+	authenticator := NewAuthenticator(testKey, "", users, nil, nil)
+
+	if !authenticator.Enabled() {
+		t.Errorf("users don't need to sign in")
+	}
+
+	_, err := authenticator.SignIn("", "", "")
+	if err == nil {
+		t.Errorf("SignIn worked without a login")
+	}
+
+	_, err = authenticator.SignIn("anna", "boris's password", "")
+	if err == nil {
+		t.Errorf("SignIn accepted the password of another user")
+	}
+
+	token, err := authenticator.SignIn("boris", "boris's password", "")
+	if err != nil {
+		t.Fatalf("SignIn returned %v", err)
+	}
+
+	id, err := authenticator.Verify(token)
+	if err != nil || id != 2 {
+		t.Errorf("Verify returned %d, %v", id, err)
+	}
+
+	// A token can't be passed off as another user's.
+	forged, err := authenticator.sign(claims{Subject: 1, Expires: time.Now().Add(time.Hour).Unix()}, "hash 2")
+	if err != nil {
+		t.Fatalf("sign returned %v", err)
+	}
+
+	_, err = authenticator.Verify(forged)
+	if err == nil {
+		t.Errorf("Verify accepted a token signed for another user")
+	}
+
+	// Nor as user 0's when there is no password.
+	forged, err = authenticator.sign(claims{Subject: 0, Expires: time.Now().Add(time.Hour).Unix()}, "")
+	if err != nil {
+		t.Fatalf("sign returned %v", err)
+	}
+
+	_, err = authenticator.Verify(forged)
+	if err == nil {
+		t.Errorf("Verify accepted a token for user 0 without a password")
+	}
+
+	users[2] = [3]string{"boris", "new password", "hash 3"}
+	_, err = authenticator.Verify(token)
+	if err == nil {
+		t.Errorf("a token outlived a change of the password")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var seen int64
+	handler := func(authenticator *Authenticator) http.Handler {
+		return authenticator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = UserId(r.Context())
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+
+	authenticator := NewAuthenticator(testKey, "secret", fakeUsers{7: {"anna", "anna's password", "hash"}}, nil, nil)
+	token, err := authenticator.SignIn("", "secret", "")
+	if err != nil {
+		t.Fatalf("SignIn returned %v", err)
+	}
+
+	userToken, err := authenticator.SignIn("anna", "anna's password", "")
+	if err != nil {
+		t.Fatalf("SignIn returned %v", err)
+	}
+
+	tbl := []struct {
+		authenticator *Authenticator
+		cookie        string
+		status        int
+		user          int64
+	}{
+		{authenticator, token, http.StatusOK, 0},
+		{authenticator, userToken, http.StatusOK, 7},
+		{authenticator, "", http.StatusUnauthorized, 0},
+		{authenticator, "forged", http.StatusUnauthorized, 0},
+		{NewAuthenticator(testKey, "changed", nil, nil, nil), token, http.StatusUnauthorized, 0},
+		{NewAuthenticator(testKey, "", nil, nil, nil), "", http.StatusOK, 0},
+	}
+	for i, v := range tbl {
+		request := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+		if v.cookie != "" {
+			request.AddCookie(&http.Cookie{Name: CookieName, Value: v.cookie})
+		}
+
+		seen = -1
+		recorder := httptest.NewRecorder()
+		handler(v.authenticator).ServeHTTP(recorder, request)
+		if recorder.Code != v.status {
+			t.Errorf("case %d: status %d, want %d", i, recorder.Code, v.status)
+		}
+
+		if v.status == http.StatusOK && seen != v.user {
+			t.Errorf("case %d: user %d, want %d", i, seen, v.user)
+		}
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todo.key")
+
+	key, err := LoadKey(path)
+	if err != nil || len(key) != keySize {
+		t.Fatalf("LoadKey returned %d bytes, %v", len(key), err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("the key file has mode %v, %v", info.Mode(), err)
+	}
+
+	again, err := LoadKey(path)
+	if err != nil || string(again) != string(key) {
+		t.Errorf("LoadKey returned another key the second time, %v", err)
+	}
+
+	err = os.WriteFile(path, []byte("short"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadKey(path)
+	if err == nil {
+		t.Errorf("LoadKey accepted a short key")
+	}
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPIssuer names the server in authenticator apps.
+	TOTPIssuer = "todo-list"
+	// TOTPPeriod is how long a code lasts, as most authenticator apps
+	// expect.
+	TOTPPeriod = 30 * time.Second
+	// TOTPDigits is how long a code is.
+	TOTPDigits = 6
+	// totpWindow is how many periods a code may be off by, for clocks that
+	// disagree and codes typed in at the end of their period.
+	totpWindow = 1
+
+	// RecoveryCodeCount is how many recovery codes are handed out at a time.
+	RecoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random 160-bit secret in base32, as RFC 4226
+// recommends and authenticator apps take it.
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", fmt.Errorf("error creating the secret")
+	}
+
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPURI returns the otpauth URI of a secret, which authenticator apps read
+// from a QR code.
+func TOTPURI(account, secret string) string {
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {TOTPIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(TOTPDigits)},
+		"period":    {fmt.Sprint(int(TOTPPeriod / time.Second))},
+	}
+
+	return "otpauth://totp/" + url.PathEscape(TOTPIssuer+":"+account) + "?" + query.Encode()
+}
+
+// CheckTOTP returns the time step of a code if it is the code of a secret
+// at a time, or at a period before or after it, as RFC 6238 describes. The
+// step lets a code be used only once.
+func CheckTOTP(secret, code string, at time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != TOTPDigits {
+		return 0, false
+	}
+
+	step := at.Unix() / int64(TOTPPeriod/time.Second)
+	found := int64(-1)
+	for offset := int64(-totpWindow); offset <= totpWindow; offset++ {
+		// Every step is checked, so that the time taken doesn't tell which
+		// one matched.
+		if hmac.Equal([]byte(hotp(key, uint64(step+offset))), []byte(code)) {
+			found = step + offset
+		}
+	}
+
+	return found, found >= 0
+}
+
+// hotp is the code of a key for a counter, as in RFC 4226.
+func hotp(key []byte, counter uint64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for range TOTPDigits {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", TOTPDigits, value%modulo)
+}
+
+// IsTOTPCode reports whether a code looks like a TOTP code rather than a
+// recovery code.
+func IsTOTPCode(code string) bool {
+	if len(code) != TOTPDigits {
+		return false
+	}
+
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewRecoveryCodes returns random single-use codes that stand in for TOTP
+// codes when the authenticator app is lost, and their hashes.
+func NewRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		data := make([]byte, 8)
+		_, err := rand.Read(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating recovery codes")
+		}
+
+		code := hex.EncodeToString(data)
+		codes[i] = code[:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:]
+		hashes[i] = HashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode is how recovery codes are stored. Like API tokens they
+// are random enough for SHA-256. Case, dashes and spaces don't matter.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte("todo-list recovery code\x00" + code))
+
+	return hex.EncodeToString(hash[:])
+}
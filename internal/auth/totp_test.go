@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHOTP(t *testing.T) {
+	// The SHA-1 test vectors of RFC 6238, which has 8 digits.
+	key := []byte("12345678901234567890")
+	tbl := []struct {
+		seconds int64
+		code    string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, v := range tbl {
+		code := hotp(key, uint64(v.seconds/30))
+		if code != v.code[8-TOTPDigits:] {
+			t.Errorf("hotp at %d returned %s, want %s", v.seconds, code, v.code[8-TOTPDigits:])
+		}
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	at := time.Unix(1111111111, 0)
+
+	tbl := []struct {
+		at   time.Time
+		code string
+		step int64
+		ok   bool
+	}{
+		{at, "050471", 37037037, true},
+		{at.Add(TOTPPeriod), "050471", 37037037, true},
+		{at.Add(-TOTPPeriod), "050471", 37037037, true},
+		{at.Add(2 * TOTPPeriod), "050471", 0, false},
+		{at, "050472", 0, false},
+		{at, "50471", 0, false},
+		{at, "", 0, false},
+	}
+	for _, v := range tbl {
+		step, ok := CheckTOTP(secret, v.code, v.at)
+		if ok != v.ok || ok && step != v.step {
+			t.Errorf("CheckTOTP(%q) at %v returned %d, %v", v.code, v.at, step, ok)
+		}
+	}
+
+	_, ok := CheckTOTP("not base32!", "050471", at)
+	if ok {
+		t.Errorf("CheckTOTP accepted an invalid secret")
+	}
+
+	fresh, err := NewTOTPSecret()
+	if err != nil || len(fresh) != 32 {
+		t.Errorf("NewTOTPSecret returned %q, %v", fresh, err)
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("anna@example.com", "GEZDGNBVGY3TQOJQ")
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("TOTPURI returned %q", uri)
+	}
+
+	query := parsed.Query()
+	if parsed.Scheme != "otpauth" || parsed.Host != "totp" || parsed.Path != "/todo-list:anna@example.com" ||
+		query.Get("secret") != "GEZDGNBVGY3TQOJQ" || query.Get("issuer") != TOTPIssuer || query.Get("digits") != fmt.Sprint(TOTPDigits) {
+		t.Errorf("TOTPURI returned %q", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil || len(codes) != RecoveryCodeCount || len(hashes) != RecoveryCodeCount {
+		t.Fatalf("NewRecoveryCodes returned %v, %v", codes, err)
+	}
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if seen[code] || IsTOTPCode(code) || strings.Contains(hashes[i], code) {
+			t.Errorf("NewRecoveryCodes returned %q, %q", code, hashes[i])
+		}
+
+		seen[code] = true
+		typed := strings.ToUpper(strings.ReplaceAll(code, "-", " "))
+		if HashRecoveryCode(typed) != hashes[i] {
+			t.Errorf("%q doesn't match %q", typed, code)
+		}
+	}
+}
+
+// fakeFactors keeps the second factors of users as the one code they take.
+type fakeFactors map[int64]string
+
+func (factors fakeFactors) CheckSecondFactor(id int64, code string) error {
+	want, ok := factors[id]
+	if !ok {
+		return nil
+	}
+
+	if code == "" {
+		return fmt.Errorf("the two-factor code is required")
+	}
+
+	if code != want {
+		return fmt.Errorf("wrong two-factor code")
+	}
+
+	return nil
+}
+
+func TestSignInSecondFactor(t *testing.T) {
+	users := fakeUsers{1: {"anna", "anna's password", "hash 1"}, 2: {"boris", "boris's password", "hash 2"}}
+	authenticator := NewAuthenticator(testKey, "secret", users, nil, fakeFactors{0: "123456", 1: "654321"})
+
+	tbl := []struct {
+		login    string
+		password string
+		code     string
+		ok       bool
+	}{
+		{"anna", "anna's password", "654321", true},
+		{"anna", "anna's password", "", false},
+		{"anna", "anna's password", "123456", false},
+		{"anna", "boris's password", "654321", false},
+		{"boris", "boris's password", "", true},
+		{"", "secret", "123456", true},
+		{"", "secret", "", false},
+		{"", "wrong", "123456", false},
+	}
+	for _, v := range tbl {
+		_, err := authenticator.SignIn(v.login, v.password, v.code)
+		if (err == nil) != v.ok {
+			t.Errorf("SignIn(%q, %q, %q) returned %v", v.login, v.password, v.code, err)
+		}
+	}
+}
@@ -9,8 +9,16 @@ import (
 )
 
 var (
-	Addr     string
-	PathToDB string
+	Addr           string
+	PathToDB       string
+	PathToKey      string
+	PathToHolidays string
+	Password       string
+
+	OIDCIssuer       string
+	OIDCClientId     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
 )
 
 func init() {
@@ -21,4 +29,12 @@ func init() {
 
 	Addr = fmt.Sprintf(":%s", os.Getenv("TODO_PORT"))
 	PathToDB = fmt.Sprintf("%s", os.Getenv("TODO_DBFILE"))
+	PathToKey = os.Getenv("TODO_KEYFILE")
+	PathToHolidays = os.Getenv("TODO_HOLIDAYS")
+	Password = os.Getenv("TODO_PASSWORD")
+
+	OIDCIssuer = os.Getenv("TODO_OIDC_ISSUER")
+	OIDCClientId = os.Getenv("TODO_OIDC_CLIENT_ID")
+	OIDCClientSecret = os.Getenv("TODO_OIDC_CLIENT_SECRET")
+	OIDCRedirectURL = os.Getenv("TODO_OIDC_REDIRECT_URL")
 }
@@ -5,12 +5,61 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/capybara120404/todo-list/internal/utils"
+	"github.com/mattn/go-sqlite3"
 )
 
+// driverName is SQLite with fold(text), which lowers the case of any
+// letters, as the built-in lower() and LIKE only know the Latin ones, and
+// rule_type(repeat), which names the type of a repeat rule.
+const driverName = "sqlite3_todo"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			err := conn.RegisterFunc("fold", strings.ToLower, true)
+			if err != nil {
+				return err
+			}
+
+			return conn.RegisterFunc("rule_type", utils.RuleTypeName, true)
+		},
+	})
+}
+
+// columns lists the scheduler columns added after the table was first
+// created. They are added to older databases when they are opened, and fill
+// sets them for the rows that are already there.
+var columns = []struct {
+	name       string
+	definition string
+	fill       string
+}{
+	{"time", "TEXT NOT NULL DEFAULT ''", ""},
+	{"skip", "TEXT NOT NULL DEFAULT ''", ""},
+	{"anchor", "TEXT NOT NULL DEFAULT ''", ""},
+	{"duration", "TEXT NOT NULL DEFAULT ''", ""},
+	{"timezone", "TEXT NOT NULL DEFAULT ''", ""},
+	{"priority", "INTEGER NOT NULL DEFAULT 0", ""},
+	// starts_at is the start of a task in UTC as "20060102 15:04", which
+	// sorts tasks in different timezones. Older tasks are in the server's
+	// local time, which SQLite's 'utc' modifier converts from.
+	{"starts_at", "TEXT NOT NULL DEFAULT ''", `UPDATE scheduler SET starts_at = strftime('%Y%m%d %H:%M',
+		substr(date, 1, 4) || '-' || substr(date, 5, 2) || '-' || substr(date, 7, 2) || ' ' || CASE time WHEN '' THEN '00:00' ELSE time END,
+		'utc')`},
+	// owner_id is the user a task belongs to. Tasks from before there were
+	// users belong to 0, the user who signs in with TODO_PASSWORD.
+	{"owner_id", "INTEGER NOT NULL DEFAULT 0", ""},
+}
+
 type Connecter struct {
 	DB *sql.DB
+
+	// FullTextSearch is set when SQLite was built with FTS5, i.e. with the
+	// sqlite_fts5 build tag, and the scheduler_fts index can be queried.
+	FullTextSearch bool
 }
 
 func OpenOrCreate(name string) (*Connecter, error) {
@@ -27,7 +76,7 @@ func OpenOrCreate(name string) (*Connecter, error) {
 		create = true
 	}
 
-	db, err := sql.Open("sqlite3", dbFile)
+	db, err := sql.Open(driverName, dbFile)
 	if err != nil {
 		return nil, fmt.Errorf("error opening database: %v", err)
 	}
@@ -39,7 +88,17 @@ func OpenOrCreate(name string) (*Connecter, error) {
 		}
 	}
 
-	return &Connecter{DB: db}, nil
+	err = migrateDatabase(db)
+	if err != nil {
+		return nil, fmt.Errorf("error migrating database: %v", err)
+	}
+
+	fullTextSearch, err := createSearchIndex(db)
+	if err != nil {
+		return nil, fmt.Errorf("error creating search index: %v", err)
+	}
+
+	return &Connecter{DB: db, FullTextSearch: fullTextSearch}, nil
 }
 
 func (c *Connecter) Close() {
@@ -52,9 +111,184 @@ func createDatabase(db *sql.DB) error {
 		date TEXT NOT NULL,
 		title TEXT NOT NULL,
 		comment TEXT NOT NULL,
-		repeat TEXT NOT NULL CHECK(length(repeat <= 128))
+		repeat TEXT NOT NULL CHECK(length(repeat) <= 1024)
 	);
 	CREATE INDEX date_index ON scheduler(date);`
 	_, err := db.Exec(query)
 	return err
 }
+
+func migrateDatabase(db *sql.DB) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info('scheduler')")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return err
+		}
+
+		existing[name] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		if existing[column.name] {
+			continue
+		}
+
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE scheduler ADD COLUMN %s %s", column.name, column.definition))
+		if err != nil {
+			return err
+		}
+
+		if column.fill != "" {
+			_, err = db.Exec(column.fill)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS starts_at_index ON scheduler(starts_at);
+	CREATE INDEX IF NOT EXISTS owner_index ON scheduler(owner_id, starts_at);
+	CREATE TABLE IF NOT EXISTS lists (
+		owner_id INTEGER NOT NULL DEFAULT 0,
+		name TEXT NOT NULL,
+		filter TEXT NOT NULL CHECK(length(filter) <= 1024),
+		PRIMARY KEY (owner_id, name)
+	);
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		login TEXT NOT NULL UNIQUE,
+		password TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		owner_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		hash TEXT NOT NULL UNIQUE,
+		created_at TEXT NOT NULL,
+		used_at TEXT NOT NULL DEFAULT ''
+	);
+	CREATE TABLE IF NOT EXISTS user_identities (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id INTEGER NOT NULL,
+		PRIMARY KEY (issuer, subject)
+	);
+	CREATE TABLE IF NOT EXISTS totp (
+		user_id INTEGER PRIMARY KEY,
+		secret TEXT NOT NULL,
+		confirmed INTEGER NOT NULL DEFAULT 0,
+		last_step INTEGER NOT NULL DEFAULT 0,
+		failures INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		user_id INTEGER NOT NULL,
+		hash TEXT NOT NULL,
+		PRIMARY KEY (user_id, hash)
+	);`)
+	if err != nil {
+		return err
+	}
+
+	return migrateLists(db)
+}
+
+// migrateLists gives the lists from before there were users an owner_id.
+// It is part of the key, so the table is built again rather than altered.
+// The lists go to user 0, like the tasks.
+func migrateLists(db *sql.DB) error {
+	var owned bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM pragma_table_info('lists') WHERE name = 'owner_id')").Scan(&owned)
+	if err != nil || owned {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`CREATE TABLE lists_owned (
+		owner_id INTEGER NOT NULL DEFAULT 0,
+		name TEXT NOT NULL,
+		filter TEXT NOT NULL CHECK(length(filter) <= 1024),
+		PRIMARY KEY (owner_id, name)
+	);
+	INSERT INTO lists_owned (owner_id, name, filter) SELECT 0, name, filter FROM lists;
+	DROP TABLE lists;
+	ALTER TABLE lists_owned RENAME TO lists;`)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// createSearchIndex creates the full-text index of titles and comments and
+// reports whether it can be used. The index is kept up to date by the
+// repository and only filled again here when it is out of step with the
+// tasks, e.g. when it is new or tasks were written by a build without
+// FTS5. The unicode61 tokenizer folds the case of Cyrillic as well as
+// Latin letters.
+func createSearchIndex(db *sql.DB) (bool, error) {
+	var enabled bool
+	err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled)
+	if err != nil || !enabled {
+		return false, err
+	}
+
+	_, err = db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS scheduler_fts USING fts5(
+		title, comment,
+		tokenize = 'unicode61 remove_diacritics 2', prefix = '2 3'
+	);`)
+	if err != nil {
+		return false, err
+	}
+
+	var stale bool
+	err = db.QueryRow(`SELECT (SELECT count(*) FROM scheduler) != (SELECT count(*) FROM scheduler_fts)
+		OR EXISTS (SELECT 1 FROM scheduler LEFT JOIN scheduler_fts ON scheduler_fts.rowid = scheduler.id
+			WHERE scheduler_fts.rowid IS NULL
+			OR scheduler_fts.title IS NOT scheduler.title
+			OR scheduler_fts.comment IS NOT scheduler.comment)`).Scan(&stale)
+	if err != nil {
+		return false, err
+	}
+
+	if !stale {
+		return true, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM scheduler_fts;
+	INSERT INTO scheduler_fts(rowid, title, comment) SELECT id, title, comment FROM scheduler;`)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/capybara120404/todo-list/internal/auth"
+	"github.com/capybara120404/todo-list/internal/repository"
+	"github.com/capybara120404/todo-list/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type apiTokenHandler struct {
+	repository *repository.APITokenRepository
+}
+
+func NewAPITokenHandler(repository *repository.APITokenRepository) *apiTokenHandler {
+	return &apiTokenHandler{
+		repository: repository,
+	}
+}
+
+// tokens is the repository for the tokens of the user making a request.
+func (handler *apiTokenHandler) tokens(r *http.Request) *repository.APITokenRepository {
+	return handler.repository.Owner(auth.UserId(r.Context()))
+}
+
+func (handler *apiTokenHandler) GetAllTokensHandler(w http.ResponseWriter, r *http.Request) {
+	tokens, err := handler.tokens(r).GetAll()
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"tokens": tokens})
+}
+
+func (handler *apiTokenHandler) CreateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := repository.GetAPITokenFromBody(r)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = handler.tokens(r).Add(&token)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(token)
+}
+
+func (handler *apiTokenHandler) RevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.WriteJSONError(w, "invalid token Id format", http.StatusBadRequest)
+		return
+	}
+
+	err = handler.tokens(r).Revoke(id)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{})
+}
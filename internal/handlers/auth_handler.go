@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/capybara120404/todo-list/internal/auth"
+	"github.com/capybara120404/todo-list/internal/repository"
+	"github.com/capybara120404/todo-list/internal/utils"
+)
+
+type authHandler struct {
+	authenticator *auth.Authenticator
+	users         *repository.UserRepository
+	// adopt hands the tasks from before there were users over to the first
+	// user, unless they belong to whoever signs in with TODO_PASSWORD.
+	adopt bool
+}
+
+func NewAuthHandler(authenticator *auth.Authenticator, users *repository.UserRepository, password string) *authHandler {
+	return &authHandler{
+		authenticator: authenticator,
+		users:         users,
+		adopt:         password == "",
+	}
+}
+
+func (handler *authHandler) SignInHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := repository.GetUserFromBody(r)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, err := handler.authenticator.SignIn(user.Login, user.Password, user.Code)
+	if err != nil {
+		// Not 401, which sends the web interface back to the login page
+		// instead of showing the error.
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"token": token})
+}
+
+// RegisterHandler adds a user and signs them in. It is behind the
+// authentication middleware, so only the first user registers on their own.
+func (handler *authHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := repository.GetUserFromBody(r)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	password := user.Password
+	id, err := handler.users.Add(&user, handler.adopt)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, err := handler.authenticator.SignIn(user.Login, password, "")
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"id": id, "token": token})
+}
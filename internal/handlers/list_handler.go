@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/capybara120404/todo-list/internal/auth"
+	"github.com/capybara120404/todo-list/internal/repository"
+	"github.com/capybara120404/todo-list/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type listHandler struct {
+	repository *repository.ListRepository
+	tasks      *repository.TaskRepository
+}
+
+func NewListHandler(repository *repository.ListRepository, tasks *repository.TaskRepository) *listHandler {
+	return &listHandler{
+		repository: repository,
+		tasks:      tasks,
+	}
+}
+
+// lists is the repository for the lists of the user making a request.
+func (handler *listHandler) lists(r *http.Request) *repository.ListRepository {
+	return handler.repository.Owner(auth.UserId(r.Context()))
+}
+
+func (handler *listHandler) GetAllListsHandler(w http.ResponseWriter, r *http.Request) {
+	lists, err := handler.lists(r).GetAll()
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"lists": lists})
+}
+
+func (handler *listHandler) SaveListHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := repository.GetListFromBody(r)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = handler.lists(r).Save(&list)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (handler *listHandler) DeleteListHandler(w http.ResponseWriter, r *http.Request) {
+	err := handler.lists(r).Delete(chi.URLParam(r, "name"))
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{})
+}
+
+// GetListTasksHandler lists the tasks that match a list's filter. It takes
+// the same search, sort, limit and cursor as GetAllTasksHandler.
+func (handler *listHandler) GetListTasksHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := handler.lists(r).GetByName(chi.URLParam(r, "name"))
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := handler.tasks.Owner(auth.UserId(r.Context())).GetAll(r.FormValue("search"), list.Filter, r.FormValue("sort"), r.FormValue("limit"), r.FormValue("cursor"))
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeTaskPage(w, r, page)
+}
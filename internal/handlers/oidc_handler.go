@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/capybara120404/todo-list/internal/auth"
+	"github.com/capybara120404/todo-list/internal/repository"
+	"github.com/capybara120404/todo-list/internal/utils"
+)
+
+type oidcHandler struct {
+	oidc          *auth.OIDC
+	authenticator *auth.Authenticator
+	users         *repository.UserRepository
+	// adopt is as for registering, see authHandler.
+	adopt bool
+}
+
+func NewOIDCHandler(oidc *auth.OIDC, authenticator *auth.Authenticator, users *repository.UserRepository, password string) *oidcHandler {
+	return &oidcHandler{
+		oidc:          oidc,
+		authenticator: authenticator,
+		users:         users,
+		adopt:         password == "",
+	}
+}
+
+// OIDCLoginHandler sends the user to the identity provider, keeping what the
+// callback needs to check the answer in a cookie.
+func (handler *oidcHandler) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	login, err := auth.NewOIDCLogin()
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := handler.oidc.AuthCodeURL(r.Context(), login)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.OIDCCookieName,
+		Value:    login.String(),
+		Path:     "/api/oidc",
+		MaxAge:   int(auth.OIDCLoginLifetime / time.Second),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler is where the identity provider sends the user back.
+// It signs in the user the provider vouches for, registering them the first
+// time, and sends them on to the web interface with a token in the cookie.
+func (handler *oidcHandler) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		utils.WriteJSONError(w, "the identity provider refused the sign-in: "+query.Get("error"), http.StatusBadRequest)
+		return
+	}
+
+	cookie, err := r.Cookie(auth.OIDCCookieName)
+	if err != nil {
+		utils.WriteJSONError(w, "the sign-in has expired, start again", http.StatusBadRequest)
+		return
+	}
+
+	// The state is single-use.
+	http.SetCookie(w, &http.Cookie{Name: auth.OIDCCookieName, Path: "/api/oidc", MaxAge: -1})
+
+	login, err := auth.ParseOIDCLogin(cookie.Value)
+	if err != nil || !login.CheckState(query.Get("state")) {
+		utils.WriteJSONError(w, "invalid sign-in state", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := handler.oidc.Exchange(r.Context(), query.Get("code"), login)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := handler.users.External(identity.Issuer, identity.Subject, []string{identity.Username, identity.Email}, handler.adopt)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, err := handler.authenticator.Issue(id)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.CookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(auth.TokenLifetime / time.Second),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
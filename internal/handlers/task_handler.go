@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/capybara120404/todo-list/internal/auth"
 	"github.com/capybara120404/todo-list/internal/repository"
 	"github.com/capybara120404/todo-list/internal/utils"
 )
@@ -20,6 +21,11 @@ func NewTaskHandler(repository *repository.TaskRepository) *taskHandler {
 	}
 }
 
+// tasks is the repository for the tasks of the user making a request.
+func (handler *taskHandler) tasks(r *http.Request) *repository.TaskRepository {
+	return handler.repository.Owner(auth.UserId(r.Context()))
+}
+
 func (handler *taskHandler) AddTaskHandler(w http.ResponseWriter, r *http.Request) {
 	task, err := repository.GetTaskFromBody(r)
 	if err != nil {
@@ -27,7 +33,7 @@ func (handler *taskHandler) AddTaskHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	id, err := handler.repository.Add(&task)
+	id, err := handler.tasks(r).Add(&task)
 	if err != nil {
 		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
 		return
@@ -50,7 +56,7 @@ func (handler *taskHandler) ChangeTaskHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = handler.repository.Change(id, &task)
+	err = handler.tasks(r).Change(id, &task)
 	if err != nil {
 		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
 		return
@@ -67,7 +73,24 @@ func (handler *taskHandler) CompleteTaskHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = handler.repository.Complete(id)
+	err = handler.tasks(r).Complete(id)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{})
+}
+
+func (handler *taskHandler) SkipTaskHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.GetAndCheckId(r)
+	if err != nil {
+		utils.WriteJSONError(w, "invalid task Id format", http.StatusBadRequest)
+		return
+	}
+
+	err = handler.tasks(r).Skip(id, r.URL.Query().Get("date"))
 	if err != nil {
 		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
 		return
@@ -84,7 +107,7 @@ func (handler *taskHandler) DeleteTaskHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = handler.repository.Delete(id)
+	err = handler.tasks(r).Delete(id)
 	if err != nil {
 		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
 		return
@@ -95,13 +118,30 @@ func (handler *taskHandler) DeleteTaskHandler(w http.ResponseWriter, r *http.Req
 }
 
 func (handler *taskHandler) GetAllTasksHandler(w http.ResponseWriter, r *http.Request) {
-	tasks, err := handler.repository.GetAll()
+	page, err := handler.tasks(r).GetAll(r.FormValue("search"), r.FormValue("filter"), r.FormValue("sort"), r.FormValue("limit"), r.FormValue("cursor"))
 	if err != nil {
 		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	writeTaskPage(w, r, page)
+}
+
+// writeTaskPage writes a page of tasks, with the number of tasks on all
+// pages and the cursor of the next one in the headers.
+func writeTaskPage(w http.ResponseWriter, r *http.Request, page repository.TaskPage) {
+	tasks := page.Tasks
+	lang := utils.Language(r.Header.Get("Accept-Language"))
+	for i := range tasks {
+		describeRepeat(&tasks[i], lang)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
+
 	if tasks == nil {
 		json.NewEncoder(w).Encode(map[string]any{"tasks": make([]repository.Task, 0)})
 	} else {
@@ -116,26 +156,109 @@ func (handler *taskHandler) GetTaskByIdHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	task, err := handler.repository.GetById(id)
+	task, err := handler.tasks(r).GetById(id)
 	if err != nil {
 		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	describeRepeat(&task, utils.Language(r.Header.Get("Accept-Language")))
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(task)
 }
 
+// AgendaHandler groups tasks into overdue, today, tomorrow, this week and
+// later, as seen on the date now in the timezone tz.
+func (handler *taskHandler) AgendaHandler(w http.ResponseWriter, r *http.Request) {
+	agenda, err := handler.tasks(r).Agenda(r.FormValue("now"), r.FormValue("tz"))
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lang := utils.Language(r.Header.Get("Accept-Language"))
+	for _, group := range [][]repository.AgendaTask{agenda.Overdue, agenda.Today, agenda.Tomorrow, agenda.Week, agenda.Later} {
+		for i := range group {
+			describeRepeat(&group[i].Task, lang)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agenda)
+}
+
 func (handler *taskHandler) NexDateHandler(w http.ResponseWriter, r *http.Request) {
 	nowStr := r.FormValue("now")
 	dateStr := r.FormValue("date")
+	timeStr := r.FormValue("time")
 	repeat := r.FormValue("repeat")
+	skip := r.FormValue("skip")
+	anchor := r.FormValue("anchor")
+
+	nextDate, nextTime, err := handler.repository.CalculateNextDate(nowStr, dateStr, timeStr, repeat, skip, anchor)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if nextTime != "" {
+		fmt.Fprintln(w, nextDate, nextTime)
+	} else {
+		fmt.Fprintln(w, nextDate)
+	}
+}
+
+func (handler *taskHandler) OccurrencesHandler(w http.ResponseWriter, r *http.Request) {
+	occurrences, err := handler.repository.CalculateOccurrences(
+		r.FormValue("from"),
+		r.FormValue("date"),
+		r.FormValue("time"),
+		r.FormValue("repeat"),
+		r.FormValue("skip"),
+		r.FormValue("count"),
+	)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	nextDate, err := handler.repository.CalculateNextDate(nowStr, dateStr, repeat)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"occurrences": occurrences})
+}
+
+func (handler *taskHandler) DescribeRepeatHandler(w http.ResponseWriter, r *http.Request) {
+	text, err := utils.DescribeRepeat(r.FormValue("repeat"), utils.Language(r.Header.Get("Accept-Language")))
 	if err != nil {
 		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	fmt.Fprintln(w, nextDate)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"text": text})
+}
+
+func (handler *taskHandler) ParseRepeatHandler(w http.ResponseWriter, r *http.Request) {
+	repeat, err := utils.ParseRepeatPhrase(r.FormValue("text"))
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	text, err := utils.DescribeRepeat(repeat, utils.Language(r.Header.Get("Accept-Language")))
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"repeat": repeat, "repeat_text": text})
+}
+
+func describeRepeat(task *repository.Task, lang string) {
+	if task.Repeat == "" {
+		return
+	}
+
+	task.RepeatText, _ = utils.DescribeRepeat(task.Repeat, lang)
 }
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/capybara120404/todo-list/internal/auth"
+	"github.com/capybara120404/todo-list/internal/repository"
+	"github.com/capybara120404/todo-list/internal/utils"
+)
+
+type totpHandler struct {
+	repository *repository.TOTPRepository
+}
+
+func NewTOTPHandler(repository *repository.TOTPRepository) *totpHandler {
+	return &totpHandler{
+		repository: repository,
+	}
+}
+
+// totp is the repository for the second factor of the user making a
+// request.
+func (handler *totpHandler) totp(r *http.Request) *repository.TOTPRepository {
+	return handler.repository.Owner(auth.UserId(r.Context()))
+}
+
+func (handler *totpHandler) GetTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	totp, err := handler.totp(r).Get()
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(totp)
+}
+
+func (handler *totpHandler) EnrolTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	totp, err := handler.totp(r).Enrol()
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(totp)
+}
+
+func (handler *totpHandler) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := repository.GetTOTPFromBody(r)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	totp, err := handler.totp(r).Confirm(body.Code)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(totp)
+}
+
+func (handler *totpHandler) RecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := repository.GetTOTPFromBody(r)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	totp, err := handler.totp(r).RecoveryCodes(body.Code)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(totp)
+}
+
+func (handler *totpHandler) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := repository.GetTOTPFromBody(r)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = handler.totp(r).Disable(body.Code)
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{})
+}
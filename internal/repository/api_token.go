@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/capybara120404/todo-list/internal/auth"
+)
+
+const maxTokenNameLength = 64
+
+// apiTokenPrefixLength is how much of a token is kept to tell it apart in
+// the list of tokens.
+const apiTokenPrefixLength = len(auth.APITokenPrefix) + 6
+
+// APIToken is a long-lived token for scripts. Token is only set when the
+// token is created, and only its hash is stored.
+type APIToken struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	Scope     string `json:"scope"`
+	Prefix    string `json:"prefix"`
+	CreatedAt string `json:"created_at"`
+	UsedAt    string `json:"used_at,omitempty"`
+	Token     string `json:"token,omitempty"`
+}
+
+func GetAPITokenFromBody(request *http.Request) (APIToken, error) {
+	var token APIToken
+	var buffer bytes.Buffer
+
+	_, err := buffer.ReadFrom(request.Body)
+	if err != nil {
+		return APIToken{}, fmt.Errorf("error reading request body")
+	}
+
+	err = json.Unmarshal(buffer.Bytes(), &token)
+	if err != nil {
+		return APIToken{}, fmt.Errorf("invalid JSON format")
+	}
+
+	return token, nil
+}
+
+func isCorrectAPIToken(token *APIToken) error {
+	token.Name = strings.TrimSpace(token.Name)
+	if token.Name == "" {
+		return fmt.Errorf("the name field should not be empty")
+	}
+
+	if utf8.RuneCountInString(token.Name) > maxTokenNameLength {
+		return fmt.Errorf("the name must not be longer than %d characters", maxTokenNameLength)
+	}
+
+	if token.Scope == "" {
+		token.Scope = auth.ScopeRead
+	}
+
+	if !auth.ValidScope(token.Scope) {
+		return fmt.Errorf("the scope must be %q, %q or %q", auth.ScopeRead, auth.ScopeWrite, auth.ScopeAdmin)
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/capybara120404/todo-list/internal/auth"
+	"github.com/capybara120404/todo-list/internal/database"
+)
+
+const apiTokenTimeFormat = "20060102 15:04"
+
+// APITokenRepository keeps the API tokens of one owner, or looks up the
+// tokens of anyone by their hash.
+type APITokenRepository struct {
+	db    *sql.DB
+	owner int64
+}
+
+func NewAPITokenRepository(connecter *database.Connecter) *APITokenRepository {
+	return &APITokenRepository{
+		db: connecter.DB,
+	}
+}
+
+// Owner returns a copy of the repository for the tokens of a user.
+func (repository *APITokenRepository) Owner(id int64) *APITokenRepository {
+	owned := *repository
+	owned.owner = id
+
+	return &owned
+}
+
+// Add creates a token and sets its Id and the token itself, which can't be
+// seen again.
+func (repository *APITokenRepository) Add(token *APIToken) error {
+	err := isCorrectAPIToken(token)
+	if err != nil {
+		return err
+	}
+
+	secret, hash, err := auth.NewAPIToken()
+	if err != nil {
+		return err
+	}
+
+	token.Prefix = secret[:apiTokenPrefixLength]
+	token.CreatedAt = time.Now().UTC().Format(apiTokenTimeFormat)
+	token.UsedAt = ""
+
+	res, err := repository.db.Exec("INSERT INTO api_tokens (owner_id, name, scope, prefix, hash, created_at) VALUES (:owner, :name, :scope, :prefix, :hash, :created_at)",
+		sql.Named("owner", repository.owner),
+		sql.Named("name", token.Name),
+		sql.Named("scope", token.Scope),
+		sql.Named("prefix", token.Prefix),
+		sql.Named("hash", hash),
+		sql.Named("created_at", token.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("error inserting data into the database")
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error retrieving last insert Id")
+	}
+
+	token.Id = strconv.FormatInt(id, 10)
+	token.Token = secret
+
+	return nil
+}
+
+// GetAll returns the tokens, newest first, without the tokens themselves.
+func (repository *APITokenRepository) GetAll() ([]APIToken, error) {
+	rows, err := repository.db.Query("SELECT id, name, scope, prefix, created_at, used_at FROM api_tokens WHERE owner_id = :owner ORDER BY id DESC",
+		sql.Named("owner", repository.owner))
+	if err != nil {
+		return nil, fmt.Errorf("error querying tokens from the database")
+	}
+	defer rows.Close()
+
+	tokens := make([]APIToken, 0)
+	for rows.Next() {
+		var token APIToken
+		err = rows.Scan(&token.Id, &token.Name, &token.Scope, &token.Prefix, &token.CreatedAt, &token.UsedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning token data")
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over token rows")
+	}
+
+	return tokens, nil
+}
+
+// Revoke deletes a token, which stops working at once.
+func (repository *APITokenRepository) Revoke(id int) error {
+	res, err := repository.db.Exec("DELETE FROM api_tokens WHERE id = :id AND owner_id = :owner",
+		sql.Named("id", id),
+		sql.Named("owner", repository.owner))
+	if err != nil {
+		return fmt.Errorf("error deleting a token from the database")
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error retrieving affected rows")
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("no token found with the specified Id")
+	}
+
+	return nil
+}
+
+// Lookup returns the owner and scope of the token with a hash, whoever owns
+// it, and notes when it was used.
+func (repository *APITokenRepository) Lookup(hash string) (int64, string, error) {
+	var owner int64
+	var scope string
+	err := repository.db.QueryRow("UPDATE api_tokens SET used_at = :used_at WHERE hash = :hash RETURNING owner_id, scope",
+		sql.Named("used_at", time.Now().UTC().Format(apiTokenTimeFormat)),
+		sql.Named("hash", hash)).Scan(&owner, &scope)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", fmt.Errorf("token not found")
+		}
+
+		return 0, "", fmt.Errorf("error retrieving token from database")
+	}
+
+	return owner, scope, nil
+}
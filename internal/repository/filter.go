@@ -0,0 +1,440 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/capybara120404/todo-list/internal/utils"
+)
+
+const (
+	maxFilterLength = 1024
+	maxFilterDepth  = 16
+)
+
+type filterKind int
+
+const (
+	filterText filterKind = iota
+	filterDate
+	filterTime
+	filterNumber
+)
+
+// filterFields are the fields a filter can test, with the column of each.
+var filterFields = map[string]struct {
+	column string
+	kind   filterKind
+}{
+	"date":     {"scheduler.date", filterDate},
+	"time":     {"scheduler.time", filterTime},
+	"title":    {"scheduler.title", filterText},
+	"comment":  {"scheduler.comment", filterText},
+	"repeat":   {"scheduler.repeat", filterText},
+	"skip":     {"scheduler.skip", filterText},
+	"anchor":   {"scheduler.anchor", filterText},
+	"duration": {"scheduler.duration", filterText},
+	"timezone": {"scheduler.timezone", filterText},
+	"priority": {"scheduler.priority", filterNumber},
+	"created":  {"scheduler.id", filterNumber},
+	"id":       {"scheduler.id", filterNumber},
+}
+
+var filterComparisons = map[string]bool{"=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+// filterParser turns a filter such as
+//
+//	date>=today AND (repeat:none OR title~"report")
+//
+// into an SQL condition. Fields and operators come from fixed lists and
+// every value is passed as a parameter.
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	depth  int
+	now    time.Time
+	args   []any
+}
+
+func parseFilter(filter string, now time.Time) (string, []any, error) {
+	if len(filter) > maxFilterLength {
+		return "", nil, fmt.Errorf("the filter must not be longer than %d characters", maxFilterLength)
+	}
+
+	tokens, err := splitFilter(filter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(tokens) == 0 {
+		return "", nil, nil
+	}
+
+	parser := filterParser{tokens: tokens, now: now}
+
+	condition, err := parser.or()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if parser.pos < len(parser.tokens) {
+		return "", nil, fmt.Errorf("unexpected %q in the filter", parser.tokens[parser.pos].text)
+	}
+
+	return condition, parser.args, nil
+}
+
+// splitFilter splits a filter into parentheses, words, operators and
+// values. A value runs from its operator to the next space or parenthesis,
+// so that "time>=09:00" is a single condition.
+func splitFilter(filter string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(filter)
+
+	afterOperator := false
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '(' || r == ')':
+			tokens = append(tokens, filterToken{text: string(r)})
+			i++
+			continue
+		case r == '"':
+			var value strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+
+				value.WriteRune(runes[i])
+			}
+
+			if i == len(runes) {
+				return nil, fmt.Errorf("unterminated string in the filter")
+			}
+
+			tokens = append(tokens, filterToken{text: value.String(), quoted: true})
+			afterOperator = false
+			i++
+			continue
+		}
+
+		start := i
+		if afterOperator {
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' {
+				i++
+			}
+
+			afterOperator = false
+		} else if isFilterOperator(r) {
+			for i < len(runes) && isFilterOperator(runes[i]) {
+				i++
+			}
+
+			afterOperator = true
+		} else {
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' && runes[i] != '"' && !isFilterOperator(runes[i]) {
+				i++
+			}
+		}
+
+		tokens = append(tokens, filterToken{text: string(runes[start:i])})
+	}
+
+	return tokens, nil
+}
+
+func isFilterOperator(r rune) bool {
+	return strings.ContainsRune("<>=!~:", r)
+}
+
+func (parser *filterParser) peek() (filterToken, bool) {
+	if parser.pos >= len(parser.tokens) {
+		return filterToken{}, false
+	}
+
+	return parser.tokens[parser.pos], true
+}
+
+func (parser *filterParser) keyword(word string) bool {
+	token, ok := parser.peek()
+	if ok && !token.quoted && strings.EqualFold(token.text, word) {
+		parser.pos++
+		return true
+	}
+
+	return false
+}
+
+func (parser *filterParser) or() (string, error) {
+	left, err := parser.and()
+	if err != nil {
+		return "", err
+	}
+
+	conditions := []string{left}
+	for parser.keyword("OR") {
+		right, err := parser.and()
+		if err != nil {
+			return "", err
+		}
+
+		conditions = append(conditions, right)
+	}
+
+	if len(conditions) == 1 {
+		return left, nil
+	}
+
+	return "(" + strings.Join(conditions, " OR ") + ")", nil
+}
+
+// and also joins conditions written one after another, without AND.
+func (parser *filterParser) and() (string, error) {
+	left, err := parser.not()
+	if err != nil {
+		return "", err
+	}
+
+	conditions := []string{left}
+	for {
+		if !parser.keyword("AND") {
+			token, ok := parser.peek()
+			if !ok || token.text == ")" || !token.quoted && strings.EqualFold(token.text, "OR") {
+				break
+			}
+		}
+
+		right, err := parser.not()
+		if err != nil {
+			return "", err
+		}
+
+		conditions = append(conditions, right)
+	}
+
+	if len(conditions) == 1 {
+		return left, nil
+	}
+
+	return "(" + strings.Join(conditions, " AND ") + ")", nil
+}
+
+func (parser *filterParser) not() (string, error) {
+	if parser.keyword("NOT") {
+		condition, err := parser.not()
+		if err != nil {
+			return "", err
+		}
+
+		return "NOT " + condition, nil
+	}
+
+	token, ok := parser.peek()
+	if !ok {
+		return "", fmt.Errorf("the filter ends too early")
+	}
+
+	if token.text != "(" || token.quoted {
+		return parser.condition()
+	}
+
+	parser.depth++
+	if parser.depth > maxFilterDepth {
+		return "", fmt.Errorf("the filter is nested too deeply")
+	}
+
+	parser.pos++
+	condition, err := parser.or()
+	if err != nil {
+		return "", err
+	}
+
+	token, ok = parser.peek()
+	if !ok || token.text != ")" || token.quoted {
+		return "", fmt.Errorf("missing ) in the filter")
+	}
+
+	parser.pos++
+	parser.depth--
+
+	return "(" + condition + ")", nil
+}
+
+func (parser *filterParser) condition() (string, error) {
+	if parser.pos+3 > len(parser.tokens) {
+		return "", fmt.Errorf("incomplete condition %q in the filter", parser.tokens[parser.pos].text)
+	}
+
+	name, operator, value := parser.tokens[parser.pos], parser.tokens[parser.pos+1], parser.tokens[parser.pos+2]
+	parser.pos += 3
+
+	field, ok := filterFields[strings.ToLower(name.text)]
+	if name.quoted || !ok {
+		return "", fmt.Errorf("unknown field %q in the filter", name.text)
+	}
+
+	if operator.quoted {
+		return "", fmt.Errorf("unexpected %q in the filter", operator.text)
+	}
+
+	if operator.text == ":" {
+		return parser.keywordCondition(strings.ToLower(name.text), field.column, field.kind, value.text)
+	}
+
+	switch field.kind {
+	case filterDate:
+		date, err := parser.date(value.text)
+		if err != nil {
+			return "", err
+		}
+
+		return parser.compare(field.column, operator.text, date)
+	case filterTime:
+		_, err := time.Parse(utils.TimeFormat, value.text)
+		if err != nil {
+			return "", fmt.Errorf("invalid time %q in the filter", value.text)
+		}
+
+		return parser.compare(field.column, operator.text, value.text)
+	case filterNumber:
+		number, err := strconv.ParseInt(value.text, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid number %q in the filter", value.text)
+		}
+
+		return parser.compare(field.column, operator.text, number)
+	}
+
+	switch operator.text {
+	case "~":
+		return "instr(fold(" + field.column + "), " + parser.arg(strings.ToLower(value.text)) + ") > 0", nil
+	case "!~":
+		return "instr(fold(" + field.column + "), " + parser.arg(strings.ToLower(value.text)) + ") = 0", nil
+	case "=", "!=":
+		return parser.compare(field.column, operator.text, value.text)
+	}
+
+	return "", fmt.Errorf("unsupported operator %q for %s in the filter", operator.text, name.text)
+}
+
+// keywordCondition handles "field:keyword": "any" and "none" test whether
+// a field is set, "repeat:<type>" the type of the rule, e.g. repeat:w, and
+// "date:<period>" whether the task is due today, tomorrow, this week, this
+// month or is overdue.
+func (parser *filterParser) keywordCondition(name, column string, kind filterKind, keyword string) (string, error) {
+	keyword = strings.ToLower(keyword)
+
+	switch keyword {
+	case "any":
+		if name == "priority" {
+			return column + " > 0", nil
+		}
+
+		if kind == filterNumber {
+			return "1", nil
+		}
+
+		return column + " != ''", nil
+	case "none":
+		if name == "priority" {
+			return column + " = 0", nil
+		}
+
+		if kind == filterNumber {
+			return "0", nil
+		}
+
+		return column + " = ''", nil
+	}
+
+	if name == "repeat" {
+		return "fold(rule_type(" + column + ")) = " + parser.arg(keyword), nil
+	}
+
+	if kind != filterDate {
+		return "", fmt.Errorf("unknown keyword %q for %s in the filter", keyword, name)
+	}
+
+	today := time.Date(parser.now.Year(), parser.now.Month(), parser.now.Day(), 0, 0, 0, 0, time.UTC)
+
+	var from, to time.Time
+	switch keyword {
+	case "today":
+		from, to = today, today
+	case "tomorrow":
+		from, to = today.AddDate(0, 0, 1), today.AddDate(0, 0, 1)
+	case "yesterday":
+		from, to = today.AddDate(0, 0, -1), today.AddDate(0, 0, -1)
+	case "week":
+		from = today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		to = from.AddDate(0, 0, 6)
+	case "month":
+		from = today.AddDate(0, 0, 1-today.Day())
+		to = from.AddDate(0, 1, -1)
+	case "overdue":
+		return column + " < " + parser.arg(today.Format(utils.DateFormat)), nil
+	default:
+		return "", fmt.Errorf("unknown keyword %q for %s in the filter", keyword, name)
+	}
+
+	return column + " BETWEEN " + parser.arg(from.Format(utils.DateFormat)) + " AND " + parser.arg(to.Format(utils.DateFormat)), nil
+}
+
+func (parser *filterParser) compare(column, operator string, value any) (string, error) {
+	if !filterComparisons[operator] {
+		return "", fmt.Errorf("unsupported operator %q in the filter", operator)
+	}
+
+	return column + " " + operator + " " + parser.arg(value), nil
+}
+
+// date accepts the dates a search does, as well as today, tomorrow,
+// yesterday and today+N or today-N.
+func (parser *filterParser) date(value string) (string, error) {
+	if date, ok := parseSearchDate(value); ok {
+		return date, nil
+	}
+
+	today := time.Date(parser.now.Year(), parser.now.Month(), parser.now.Day(), 0, 0, 0, 0, time.UTC)
+	value = strings.ToLower(value)
+
+	switch value {
+	case "today":
+		return today.Format(utils.DateFormat), nil
+	case "tomorrow":
+		return today.AddDate(0, 0, 1).Format(utils.DateFormat), nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1).Format(utils.DateFormat), nil
+	}
+
+	if offset, ok := strings.CutPrefix(value, "today"); ok && len(offset) > 1 && (offset[0] == '+' || offset[0] == '-') {
+		days, err := strconv.Atoi(offset)
+		if err == nil {
+			return today.AddDate(0, 0, days).Format(utils.DateFormat), nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid date %q in the filter", value)
+}
+
+func (parser *filterParser) arg(value any) string {
+	name := fmt.Sprintf("filter%d", len(parser.args))
+	parser.args = append(parser.args, sql.Named(name, value))
+
+	return ":" + name
+}
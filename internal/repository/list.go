@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxListNameLength = 64
+
+// List is a saved filter. Built-in lists can't be changed or deleted.
+type List struct {
+	Name    string `json:"name"`
+	Filter  string `json:"filter"`
+	BuiltIn bool   `json:"builtin"`
+}
+
+// builtInLists are there from the start, in this order.
+var builtInLists = []List{
+	{Name: "Today", Filter: "date:today", BuiltIn: true},
+	{Name: "Upcoming", Filter: "date>today", BuiltIn: true},
+	{Name: "Overdue", Filter: "date:overdue", BuiltIn: true},
+	{Name: "No repeat", Filter: "repeat:none", BuiltIn: true},
+}
+
+func GetListFromBody(request *http.Request) (List, error) {
+	var list List
+	var buffer bytes.Buffer
+
+	_, err := buffer.ReadFrom(request.Body)
+	if err != nil {
+		return List{}, fmt.Errorf("error reading request body")
+	}
+
+	err = json.Unmarshal(buffer.Bytes(), &list)
+	if err != nil {
+		return List{}, fmt.Errorf("invalid JSON format")
+	}
+
+	return list, nil
+}
+
+// builtInList finds a built-in list by its name, in any case.
+func builtInList(name string) (List, bool) {
+	for _, list := range builtInLists {
+		if strings.EqualFold(list.Name, name) {
+			return list, true
+		}
+	}
+
+	return List{}, false
+}
+
+func isCorrectList(list *List) error {
+	list.Name = strings.TrimSpace(list.Name)
+	if list.Name == "" {
+		return fmt.Errorf("the name field should not be empty")
+	}
+
+	if utf8.RuneCountInString(list.Name) > maxListNameLength {
+		return fmt.Errorf("the name must not be longer than %d characters", maxListNameLength)
+	}
+
+	if strings.Contains(list.Name, "/") {
+		return fmt.Errorf("the name must not contain /")
+	}
+
+	if _, ok := builtInList(list.Name); ok {
+		return fmt.Errorf("%q is a built-in list", list.Name)
+	}
+
+	if strings.TrimSpace(list.Filter) == "" {
+		return fmt.Errorf("the filter field should not be empty")
+	}
+
+	_, _, err := parseFilter(list.Filter, time.Now())
+	if err != nil {
+		return fmt.Errorf("invalid filter: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/capybara120404/todo-list/internal/database"
+)
+
+// ListRepository keeps the saved filters of one owner in the lists table.
+// Names are matched in any case.
+type ListRepository struct {
+	db    *sql.DB
+	owner int64
+}
+
+func NewListRepository(connecter *database.Connecter) *ListRepository {
+	return &ListRepository{
+		db: connecter.DB,
+	}
+}
+
+// Owner returns a copy of the repository for the lists of a user.
+func (repository *ListRepository) Owner(id int64) *ListRepository {
+	owned := *repository
+	owned.owner = id
+
+	return &owned
+}
+
+// GetAll returns the built-in lists followed by the saved ones by name.
+func (repository *ListRepository) GetAll() ([]List, error) {
+	lists := append([]List{}, builtInLists...)
+
+	rows, err := repository.db.Query("SELECT name, filter FROM lists WHERE owner_id = :owner ORDER BY fold(name)",
+		sql.Named("owner", repository.owner))
+	if err != nil {
+		return nil, fmt.Errorf("error querying lists from the database")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var list List
+		err = rows.Scan(&list.Name, &list.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning list from database")
+		}
+
+		lists = append(lists, list)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows")
+	}
+
+	return lists, nil
+}
+
+func (repository *ListRepository) GetByName(name string) (List, error) {
+	if list, ok := builtInList(name); ok {
+		return list, nil
+	}
+
+	var list List
+	err := repository.db.QueryRow("SELECT name, filter FROM lists WHERE owner_id = :owner AND fold(name) = :name",
+		sql.Named("owner", repository.owner),
+		sql.Named("name", strings.ToLower(name))).Scan(&list.Name, &list.Filter)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return List{}, fmt.Errorf("list not found")
+		}
+
+		return List{}, fmt.Errorf("error retrieving list from database")
+	}
+
+	return list, nil
+}
+
+// Save adds a list or replaces the one with the same name.
+func (repository *ListRepository) Save(list *List) error {
+	err := isCorrectList(list)
+	if err != nil {
+		return err
+	}
+
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error saving the list")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM lists WHERE owner_id = :owner AND fold(name) = :name",
+		sql.Named("owner", repository.owner),
+		sql.Named("name", strings.ToLower(list.Name)))
+	if err != nil {
+		return fmt.Errorf("error saving the list")
+	}
+
+	_, err = tx.Exec("INSERT INTO lists (owner_id, name, filter) VALUES (:owner, :name, :filter)",
+		sql.Named("owner", repository.owner),
+		sql.Named("name", list.Name),
+		sql.Named("filter", list.Filter))
+	if err != nil {
+		return fmt.Errorf("error saving the list")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error saving the list")
+	}
+
+	return nil
+}
+
+func (repository *ListRepository) Delete(name string) error {
+	if _, ok := builtInList(name); ok {
+		return fmt.Errorf("built-in lists can't be deleted")
+	}
+
+	res, err := repository.db.Exec("DELETE FROM lists WHERE owner_id = :owner AND fold(name) = :name",
+		sql.Named("owner", repository.owner),
+		sql.Named("name", strings.ToLower(name)))
+	if err != nil {
+		return fmt.Errorf("error deleting the list")
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil || count == 0 {
+		return fmt.Errorf("list not found")
+	}
+
+	return nil
+}
@@ -3,20 +3,95 @@ package repository
 import (
 	"bytes"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"html"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/capybara120404/todo-list/internal/utils"
 )
 
+const (
+	maxRepeatLength    = 1024
+	maxOccurrenceCount = 100
+)
+
+// sortKeys are the orders tasks can be listed in, each by an SQL
+// expression. Relevance only applies to full-text searches.
+var sortKeys = map[string]string{
+	"date":      "scheduler.starts_at",
+	"title":     "fold(scheduler.title)",
+	"created":   "scheduler.id",
+	"priority":  "scheduler.priority",
+	"relevance": "bm25(scheduler_fts)",
+}
+
+var searchDateLayouts = []string{"02.01.2006", "2006-01-02", utils.DateFormat}
+
+// Anchors say what the next date of a repeating task is counted from: the
+// date it was scheduled for, which is the default, or the day it was done.
+const (
+	AnchorScheduled  = "scheduled"
+	AnchorCompletion = "completion"
+)
+
+// MaxPriority is the highest priority of a task. Tasks without a priority
+// have 0.
+const MaxPriority = 3
+
 type Task struct {
 	Id      string `json:"id"`
 	Date    string `json:"date"`
+	Time    string `json:"time,omitempty"`
 	Title   string `json:"title"`
 	Comment string `json:"comment,omitempty"`
 	Repeat  string `json:"repeat"`
+	Skip    string `json:"skip,omitempty"`
+	Anchor  string `json:"anchor,omitempty"`
+
+	Duration string `json:"duration,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+
+	RepeatText string `json:"repeat_text,omitempty"`
+	Snippet    string `json:"snippet,omitempty"`
+}
+
+// TaskPage is a page of tasks. NextCursor gets the next page and is empty
+// on the last one.
+type TaskPage struct {
+	Tasks      []Task
+	Total      int
+	NextCursor string
+}
+
+// Agenda groups tasks by the day they are due, as seen from a day in a
+// timezone. Week holds the tasks after tomorrow until the end of the week,
+// which starts on Monday.
+type Agenda struct {
+	Date     string       `json:"date"`
+	Timezone string       `json:"timezone"`
+	Overdue  []AgendaTask `json:"overdue"`
+	Today    []AgendaTask `json:"today"`
+	Tomorrow []AgendaTask `json:"tomorrow"`
+	Week     []AgendaTask `json:"week"`
+	Later    []AgendaTask `json:"later"`
+}
+
+// AgendaTask is a task in an agenda. DaysLate is set for overdue tasks.
+type AgendaTask struct {
+	Task
+	DaysLate int `json:"days_late,omitempty"`
+}
+
+type Occurrence struct {
+	Date string `json:"date"`
+	Time string `json:"time,omitempty"`
 }
 
 func GetTaskFromBody(request *http.Request) (Task, error) {
@@ -39,7 +114,7 @@ func GetTaskFromBody(request *http.Request) (Task, error) {
 func convertSqlToTask(row *sql.Row) (Task, error) {
 	var task Task
 
-	err := row.Scan(&task.Id, &task.Date, &task.Title, &task.Comment, &task.Repeat)
+	err := row.Scan(&task.Id, &task.Date, &task.Time, &task.Title, &task.Comment, &task.Repeat, &task.Skip, &task.Anchor, &task.Duration, &task.Timezone, &task.Priority)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return task, fmt.Errorf("task not found")
@@ -51,13 +126,26 @@ func convertSqlToTask(row *sql.Row) (Task, error) {
 	return task, nil
 }
 
-func isCorrect(task *Task) error {
-	now := time.Now()
-
+// isCorrect checks a task and moves a past date to today or, for a
+// repeating task, to its next date. stored is the date the task already has,
+// if any: an overdue task keeps it when it is edited, so that it still shows
+// up as late.
+func isCorrect(task *Task, stored string) error {
 	if task.Title == "" {
 		return fmt.Errorf("the title field should not be empty")
 	}
 
+	location, err := task.location()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().In(location)
+
+	if len(task.Repeat) > maxRepeatLength {
+		return fmt.Errorf("the repeat rule must not be longer than %d characters", maxRepeatLength)
+	}
+
 	if task.Date == "" {
 		task.Date = now.Format(utils.DateFormat)
 	}
@@ -67,18 +155,295 @@ func isCorrect(task *Task) error {
 		return fmt.Errorf("invalid date format")
 	}
 
-	if date.Format(utils.DateFormat) < now.Format(utils.DateFormat) {
+	if task.Time != "" {
+		_, err = time.Parse(utils.TimeFormat, task.Time)
+		if err != nil {
+			return fmt.Errorf("invalid time format")
+		}
+	}
+
+	if task.Duration != "" {
+		duration, err := time.ParseDuration(task.Duration)
+		if err != nil || duration <= 0 {
+			return fmt.Errorf("invalid duration format")
+		}
+
+		if task.Time == "" {
+			return fmt.Errorf("a task with a duration needs a time")
+		}
+	}
+
+	if task.Priority < 0 || task.Priority > MaxPriority {
+		return fmt.Errorf("the priority must be between 0 and %d", MaxPriority)
+	}
+
+	if task.Anchor != "" && task.Anchor != AnchorScheduled && task.Anchor != AnchorCompletion {
+		return fmt.Errorf("the anchor must be %q or %q", AnchorScheduled, AnchorCompletion)
+	}
+
+	if task.Skip != "" {
+		for _, skipped := range strings.Split(task.Skip, ",") {
+			_, err = time.Parse(utils.DateFormat, skipped)
+			if err != nil {
+				return fmt.Errorf("invalid skipped date format")
+			}
+		}
+	}
+
+	if task.Repeat != "" {
+		err = utils.ValidateRepeat(task.Repeat)
+		if err != nil {
+			repeat, phraseErr := utils.ParseRepeatPhrase(task.Repeat)
+			if phraseErr == nil {
+				return fmt.Errorf("%v, did you mean %q?", err, repeat)
+			}
+
+			return err
+		}
+	}
+
+	// Only a past date is replaced, so only then does the rule need a next
+	// date: a series on its last occurrence is fine until it is done.
+	if task.Date != stored && date.Format(utils.DateFormat) < now.Format(utils.DateFormat) {
 		if task.Repeat == "" {
 			task.Date = now.Format(utils.DateFormat)
 		} else {
-			nextDate, err := utils.NextDate(now, task.Date, task.Repeat)
+			nextDate, nextTime, nextRepeat, err := utils.Advance(now, task.Date, task.Time, task.Repeat, task.Skip)
 			if err != nil {
 				return err
 			}
 
 			task.Date = nextDate
+			task.Time = nextTime
+			task.Repeat = nextRepeat
+			task.Skip = pruneSkipped(task.Skip, task.Date)
 		}
 	}
 
 	return nil
 }
+
+func addSkipped(skip, date string) string {
+	if utils.IsSkipped(skip, date) {
+		return skip
+	}
+
+	if skip == "" {
+		return date
+	}
+
+	dates := append(strings.Split(skip, ","), date)
+	sort.Strings(dates)
+
+	return strings.Join(dates, ",")
+}
+
+func pruneSkipped(skip, date string) string {
+	var dates []string
+
+	for _, skipped := range strings.Split(skip, ",") {
+		if skipped != "" && skipped >= date {
+			dates = append(dates, skipped)
+		}
+	}
+
+	return strings.Join(dates, ",")
+}
+
+// location is the timezone of the task, the server's one by default.
+func (task Task) location() (*time.Location, error) {
+	if task.Timezone == "" {
+		return time.Local, nil
+	}
+
+	location, err := time.LoadLocation(task.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", task.Timezone)
+	}
+
+	return location, nil
+}
+
+// now is the current time in the timezone of the task.
+func (task Task) now() time.Time {
+	location, err := task.location()
+	if err != nil {
+		location = time.Local
+	}
+
+	return time.Now().In(location)
+}
+
+// dayIn is the date of the task in a timezone. A task without a time is due
+// on its date wherever it is seen from.
+func (task Task) dayIn(location *time.Location) (time.Time, error) {
+	if task.Time == "" {
+		return time.Parse(utils.DateFormat, task.Date)
+	}
+
+	taskLocation, err := task.location()
+	if err != nil {
+		taskLocation = time.Local
+	}
+
+	start, err := time.ParseInLocation(utils.DateFormat+utils.TimeFormat, task.Date+task.Time, taskLocation)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	start = start.In(location)
+
+	return time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+// startsAt is the start of the task in UTC, which tasks are sorted by. A
+// task without a time starts at midnight.
+func (task Task) startsAt() string {
+	location, err := task.location()
+	if err != nil {
+		location = time.Local
+	}
+
+	clock := task.Time
+	if clock == "" {
+		clock = "00:00"
+	}
+
+	start, err := time.ParseInLocation(utils.DateFormat+utils.TimeFormat, task.Date+clock, location)
+	if err != nil {
+		return ""
+	}
+
+	return start.UTC().Format(utils.DateFormat + " " + utils.TimeFormat)
+}
+
+// searchDates parses a search for a date, e.g. "08.02.2024" or
+// "2024-02-08", or a range of dates such as "01.02.2024-15.02.2024".
+func searchDates(search string) (string, string, bool) {
+	search = strings.TrimSpace(search)
+
+	date, ok := parseSearchDate(search)
+	if ok {
+		return date, date, true
+	}
+
+	for _, separator := range []string{"..", "—", "–", "/", "-"} {
+		for i := strings.Index(search, separator); i >= 0; {
+			from, fromOk := parseSearchDate(search[:i])
+			to, toOk := parseSearchDate(search[i+len(separator):])
+			if fromOk && toOk {
+				if from > to {
+					from, to = to, from
+				}
+
+				return from, to, true
+			}
+
+			next := strings.Index(search[i+len(separator):], separator)
+			if next < 0 {
+				break
+			}
+
+			i += len(separator) + next
+		}
+	}
+
+	return "", "", false
+}
+
+func parseSearchDate(value string) (string, bool) {
+	for _, layout := range searchDateLayouts {
+		date, err := time.Parse(layout, strings.TrimSpace(value))
+		if err == nil {
+			return date.Format(utils.DateFormat), true
+		}
+	}
+
+	return "", false
+}
+
+// searchWords splits a search into words, dropping punctuation.
+func searchWords(search string) []string {
+	return strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchQuery turns search words into an FTS5 query that matches tasks with
+// all of them, or with words that start with them, so that "бассейн" also
+// finds "бассейна".
+func matchQuery(words []string) string {
+	var terms []string
+	for _, word := range words {
+		terms = append(terms, `"`+word+`"*`)
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// highlight marks the first search word found in the title or the comment
+// of a task and escapes it for HTML, like the snippets of the full-text
+// index.
+func highlight(task Task, words []string) string {
+	for _, text := range []string{task.Title, task.Comment} {
+		lower := strings.ToLower(text)
+		if len(lower) != len(text) {
+			continue
+		}
+
+		for _, word := range words {
+			i := strings.Index(lower, word)
+			if i < 0 {
+				continue
+			}
+
+			end := i + len(word)
+			return html.EscapeString(text[:i]) + snippetStart + html.EscapeString(text[i:end]) + snippetEnd + html.EscapeString(text[end:])
+		}
+	}
+
+	return ""
+}
+
+// markSnippet escapes a snippet from the full-text index for HTML and wraps
+// the matches in snippetStart and snippetEnd.
+func markSnippet(snippet string) string {
+	return strings.NewReplacer(matchStart, snippetStart, matchEnd, snippetEnd).Replace(html.EscapeString(snippet))
+}
+
+// taskCursor is the last task of a page: the value it was sorted by and its
+// Id, which breaks ties.
+type taskCursor struct {
+	Sort  string `json:"sort"`
+	Value any    `json:"value"`
+	Id    int64  `json:"id"`
+}
+
+func (cursor taskCursor) encode() string {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value, sort string) (taskCursor, error) {
+	var cursor taskCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return taskCursor{}, fmt.Errorf("invalid cursor")
+	}
+
+	err = json.Unmarshal(data, &cursor)
+	if err != nil || cursor.Value == nil {
+		return taskCursor{}, fmt.Errorf("invalid cursor")
+	}
+
+	if cursor.Sort != sort {
+		return taskCursor{}, fmt.Errorf("the cursor is for another sort order")
+	}
+
+	return cursor, nil
+}
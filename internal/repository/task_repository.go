@@ -2,34 +2,80 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/capybara120404/todo-list/internal/database"
 	"github.com/capybara120404/todo-list/internal/utils"
 )
 
+const taskColumns = "id, date, time, title, comment, repeat, skip, anchor, duration, timezone, priority"
+
+const (
+	defaultTaskLimit = 10
+	maxTaskLimit     = 100
+
+	snippetStart = "<mark>"
+	snippetEnd   = "</mark>"
+
+	// matchStart and matchEnd stand in for snippetStart and snippetEnd in
+	// the snippets of SQLite until the text around them is escaped. They
+	// are private use characters, which don't come up in tasks.
+	matchStart = "\ue000"
+	matchEnd   = "\ue001"
+)
+
+// TaskRepository reads and writes the tasks of one owner, the user with Id
+// 0 unless Owner says otherwise.
 type TaskRepository struct {
-	db *sql.DB
+	db             *sql.DB
+	fullTextSearch bool
+	owner          int64
 }
 
 func NewTaskRepository(connecter *database.Connecter) *TaskRepository {
 	return &TaskRepository{
-		db: connecter.DB,
+		db:             connecter.DB,
+		fullTextSearch: connecter.FullTextSearch,
 	}
 }
 
+// Owner returns a copy of the repository for the tasks of a user.
+func (repository *TaskRepository) Owner(id int64) *TaskRepository {
+	owned := *repository
+	owned.owner = id
+
+	return &owned
+}
+
 func (repository *TaskRepository) Add(task *Task) (int64, error) {
-	err := isCorrect(task)
+	err := isCorrect(task, "")
 	if err != nil {
 		return 0, err
 	}
 
-	res, err := repository.db.Exec("INSERT INTO scheduler (date, title, comment, repeat) VALUES (:date, :title, :comment, :repeat)",
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error inserting data into the database")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO scheduler (owner_id, date, time, title, comment, repeat, skip, anchor, duration, timezone, priority, starts_at) VALUES (:owner, :date, :time, :title, :comment, :repeat, :skip, :anchor, :duration, :timezone, :priority, :starts_at)",
+		sql.Named("owner", repository.owner),
 		sql.Named("date", task.Date),
+		sql.Named("time", task.Time),
 		sql.Named("title", task.Title),
 		sql.Named("comment", task.Comment),
 		sql.Named("repeat", task.Repeat),
+		sql.Named("skip", task.Skip),
+		sql.Named("anchor", task.Anchor),
+		sql.Named("duration", task.Duration),
+		sql.Named("timezone", task.Timezone),
+		sql.Named("priority", task.Priority),
+		sql.Named("starts_at", task.startsAt()),
 	)
 	if err != nil {
 		return 0, fmt.Errorf("error inserting data into the database")
@@ -40,21 +86,50 @@ func (repository *TaskRepository) Add(task *Task) (int64, error) {
 		return 0, fmt.Errorf("error retrieving last insert Id")
 	}
 
+	err = repository.index(tx, id, task)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("error inserting data into the database")
+	}
+
 	return id, nil
 }
 
 func (repository *TaskRepository) Change(id int, task *Task) error {
-	err := isCorrect(task)
+	current, err := repository.GetById(id)
+	if err != nil {
+		return err
+	}
+
+	err = isCorrect(task, current.Date)
 	if err != nil {
 		return err
 	}
 
-	res, err := repository.db.Exec("UPDATE scheduler SET date = :date, title = :title, comment = :comment, repeat = :repeat WHERE id= :id",
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error updating task in the database")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE scheduler SET date = :date, time = :time, title = :title, comment = :comment, repeat = :repeat, skip = :skip, anchor = :anchor, duration = :duration, timezone = :timezone, priority = :priority, starts_at = :starts_at WHERE id = :id AND owner_id = :owner",
 		sql.Named("date", task.Date),
+		sql.Named("time", task.Time),
 		sql.Named("title", task.Title),
 		sql.Named("comment", task.Comment),
 		sql.Named("repeat", task.Repeat),
-		sql.Named("id", id))
+		sql.Named("skip", task.Skip),
+		sql.Named("anchor", task.Anchor),
+		sql.Named("duration", task.Duration),
+		sql.Named("timezone", task.Timezone),
+		sql.Named("priority", task.Priority),
+		sql.Named("starts_at", task.startsAt()),
+		sql.Named("id", id),
+		sql.Named("owner", repository.owner))
 	if err != nil {
 		return fmt.Errorf("error updating task in the database")
 	}
@@ -68,52 +143,142 @@ func (repository *TaskRepository) Change(id int, task *Task) error {
 		return fmt.Errorf("no task found with the specified Id")
 	}
 
+	err = repository.index(tx, int64(id), task)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error updating task in the database")
+	}
+
 	return nil
 }
 
 func (repository *TaskRepository) Complete(id int) error {
-	row := repository.db.QueryRow("SELECT id, date, title, comment, repeat FROM scheduler WHERE id = :id", sql.Named("id", id))
+	task, err := repository.GetById(id)
+	if err != nil {
+		return err
+	}
 
-	task, err := convertSqlToTask(row)
+	if task.Repeat == "" {
+		return repository.Delete(id)
+	}
+
+	if task.Anchor == AnchorCompletion {
+		task.Date = task.now().Format(utils.DateFormat)
+	}
+
+	return repository.reschedule(id, task)
+}
+
+func (repository *TaskRepository) Skip(id int, date string) error {
+	task, err := repository.GetById(id)
 	if err != nil {
 		return err
 	}
 
 	if task.Repeat == "" {
-		_, err := repository.db.Exec("DELETE FROM scheduler WHERE id = :id",
-			sql.Named("id", id))
-		if err != nil {
-			return fmt.Errorf("error deleting a task from the database")
-		}
-	} else {
-		task.Date, err = utils.NextDate(time.Now(), task.Date, task.Repeat)
-		if err != nil {
-			return err
-		}
+		return fmt.Errorf("only repeating tasks can be skipped")
+	}
 
-		result, err := repository.db.Exec("UPDATE scheduler SET date = :date WHERE id = :id",
-			sql.Named("date", task.Date),
-			sql.Named("id", id))
-		if err != nil {
-			return fmt.Errorf("error updating task in the database")
-		}
+	if date == "" {
+		date = task.Date
+	}
 
-		rowsAffected, err := result.RowsAffected()
-		if err != nil {
-			return fmt.Errorf("error retrieving affected rows")
-		}
+	_, err = time.Parse(utils.DateFormat, date)
+	if err != nil {
+		return fmt.Errorf("invalid date format")
+	}
 
-		if rowsAffected == 0 {
-			return fmt.Errorf("no task found with the specified Id")
-		}
+	if date < task.Date {
+		return fmt.Errorf("the date is before the next occurrence of the task")
+	}
+
+	task.Skip = addSkipped(task.Skip, date)
+	if date == task.Date {
+		return repository.reschedule(id, task)
+	}
+
+	_, err = repository.db.Exec("UPDATE scheduler SET skip = :skip WHERE id = :id AND owner_id = :owner",
+		sql.Named("skip", task.Skip),
+		sql.Named("id", id),
+		sql.Named("owner", repository.owner))
+	if err != nil {
+		return fmt.Errorf("error updating task in the database")
+	}
+
+	return nil
+}
+
+func (repository *TaskRepository) reschedule(id int, task Task) error {
+	var err error
+
+	task.Date, task.Time, task.Repeat, err = utils.Advance(task.now(), task.Date, task.Time, task.Repeat, task.Skip)
+	if errors.Is(err, utils.ErrNoNextDate) {
+		return repository.Delete(id)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	task.Skip = pruneSkipped(task.Skip, task.Date)
+
+	result, err := repository.db.Exec("UPDATE scheduler SET date = :date, time = :time, repeat = :repeat, skip = :skip, starts_at = :starts_at WHERE id = :id AND owner_id = :owner",
+		sql.Named("date", task.Date),
+		sql.Named("time", task.Time),
+		sql.Named("repeat", task.Repeat),
+		sql.Named("skip", task.Skip),
+		sql.Named("starts_at", task.startsAt()),
+		sql.Named("id", id),
+		sql.Named("owner", repository.owner))
+	if err != nil {
+		return fmt.Errorf("error updating task in the database")
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error retrieving affected rows")
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("no task found with the specified Id")
 	}
 
 	return nil
 }
 
 func (repository *TaskRepository) Delete(id int) error {
-	_, err := repository.db.Exec("DELETE FROM scheduler WHERE id = :id",
-		sql.Named("id", id))
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error deleting a task from the database")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM scheduler WHERE id = :id AND owner_id = :owner",
+		sql.Named("id", id),
+		sql.Named("owner", repository.owner))
+	if err != nil {
+		return fmt.Errorf("error deleting a task from the database")
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error retrieving affected rows")
+	}
+
+	if rowsAffected == 0 {
+		return nil
+	}
+
+	err = repository.index(tx, int64(id), nil)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return fmt.Errorf("error deleting a task from the database")
 	}
@@ -121,34 +286,257 @@ func (repository *TaskRepository) Delete(id int) error {
 	return nil
 }
 
-func (repository *TaskRepository) GetAll() ([]Task, error) {
-	rows, err := repository.db.Query("SELECT id, date, title, comment, repeat FROM scheduler ORDER BY date LIMIT 10")
+// index puts the title and comment of a task into the full-text index, or
+// takes them out when the task is nil, in the transaction that writes the
+// task.
+func (repository *TaskRepository) index(tx *sql.Tx, id int64, task *Task) error {
+	if !repository.fullTextSearch {
+		return nil
+	}
+
+	_, err := tx.Exec("DELETE FROM scheduler_fts WHERE rowid = :id", sql.Named("id", id))
+	if err != nil {
+		return fmt.Errorf("error updating the search index")
+	}
+
+	if task == nil {
+		return nil
+	}
+
+	_, err = tx.Exec("INSERT INTO scheduler_fts(rowid, title, comment) VALUES (:id, :title, :comment)",
+		sql.Named("id", id),
+		sql.Named("title", task.Title),
+		sql.Named("comment", task.Comment))
+	if err != nil {
+		return fmt.Errorf("error updating the search index")
+	}
+
+	return nil
+}
+
+// GetAll returns a page of tasks, the first ones to start by default, and
+// the number of tasks on all pages. With a search, the tasks that match it
+// best come first, along with a snippet of the matching text. A search for
+// a date or a range of dates returns the tasks on those dates. A filter
+// narrows the tasks down further, see parseFilter.
+func (repository *TaskRepository) GetAll(search, filterStr, sort, limitStr, cursorStr string) (TaskPage, error) {
+	limit := defaultTaskLimit
+	if limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxTaskLimit {
+			return TaskPage{}, fmt.Errorf("limit must be between 1 and %d", maxTaskLimit)
+		}
+	}
+
+	from := "scheduler"
+	snippet := "''"
+	where := []string{"scheduler.owner_id = :owner"}
+	args := []any{sql.Named("owner", repository.owner)}
+
+	fullText := false
+	dateFrom, dateTo, isDate := searchDates(search)
+	words := searchWords(search)
+	switch {
+	case isDate:
+		where = append(where, "scheduler.date BETWEEN :from AND :to")
+		args = append(args, sql.Named("from", dateFrom), sql.Named("to", dateTo))
+	case len(words) > 0 && repository.fullTextSearch:
+		fullText = true
+		from = "scheduler_fts JOIN scheduler ON scheduler.id = scheduler_fts.rowid"
+		snippet = "snippet(scheduler_fts, -1, :start, :end, '…', 12)"
+		where = append(where, "scheduler_fts MATCH :query")
+		args = append(args, sql.Named("start", matchStart), sql.Named("end", matchEnd), sql.Named("query", matchQuery(words)))
+	default:
+		for i, word := range words {
+			name := fmt.Sprintf("word%d", i)
+			where = append(where, "instr(fold(scheduler.title || char(10) || scheduler.comment), :"+name+") > 0")
+			args = append(args, sql.Named(name, word))
+		}
+	}
+
+	condition, filterArgs, err := parseFilter(filterStr, time.Now())
+	if err != nil {
+		return TaskPage{}, fmt.Errorf("invalid filter: %v", err)
+	}
+
+	if condition != "" {
+		where = append(where, condition)
+		args = append(args, filterArgs...)
+	}
+
+	if sort == "" {
+		sort = "date"
+		if fullText {
+			sort = "relevance"
+		}
+	}
+
+	key, descending := strings.CutPrefix(sort, "-")
+	expression, ok := sortKeys[key]
+	if !ok {
+		return TaskPage{}, fmt.Errorf("unsupported sort key %q", key)
+	}
+
+	if key == "relevance" && !fullText {
+		expression = sortKeys["date"]
+	}
+
+	order, compare := "ASC", ">"
+	if descending {
+		order, compare = "DESC", "<"
+	}
+
+	page := TaskPage{}
+
+	filter := " WHERE " + strings.Join(where, " AND ")
+
+	err = repository.db.QueryRow("SELECT count(*) FROM "+from+filter, args...).Scan(&page.Total)
+	if err != nil {
+		return TaskPage{}, fmt.Errorf("error counting tasks in the database")
+	}
+
+	if cursorStr != "" {
+		cursor, err := decodeCursor(cursorStr, sort)
+		if err != nil {
+			return TaskPage{}, err
+		}
+
+		where = append(where, fmt.Sprintf("(%[1]s %[2]s :cursor_value OR %[1]s = :cursor_value AND scheduler.id %[2]s :cursor_id)", expression, compare))
+		args = append(args, sql.Named("cursor_value", cursor.Value), sql.Named("cursor_id", cursor.Id))
+		filter = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	args = append(args, sql.Named("limit", limit+1))
+	rows, err := repository.db.Query("SELECT "+prefixColumns("scheduler.", taskColumns)+", "+snippet+", "+expression+
+		" FROM "+from+filter+
+		" ORDER BY "+expression+" "+order+", scheduler.id "+order+" LIMIT :limit", args...)
 	if err != nil {
-		return nil, fmt.Errorf("error querying tasks from the database")
+		return TaskPage{}, fmt.Errorf("error querying tasks from the database")
 	}
 	defer rows.Close()
 
-	var tasks []Task
+	var last taskCursor
 	for rows.Next() {
 		task := Task{}
+		var value any
 
-		err := rows.Scan(&task.Id, &task.Date, &task.Title, &task.Comment, &task.Repeat)
+		err := rows.Scan(&task.Id, &task.Date, &task.Time, &task.Title, &task.Comment, &task.Repeat, &task.Skip, &task.Anchor, &task.Duration, &task.Timezone, &task.Priority, &task.Snippet, &value)
 		if err != nil {
-			return nil, fmt.Errorf("error scanning task data")
+			return TaskPage{}, fmt.Errorf("error scanning task data")
+		}
+
+		if len(page.Tasks) == limit {
+			page.NextCursor = last.encode()
+			break
+		}
+
+		if fullText {
+			task.Snippet = markSnippet(task.Snippet)
+		} else if len(words) > 0 && !isDate {
+			task.Snippet = highlight(task, words)
+		}
+
+		if bytes, ok := value.([]byte); ok {
+			value = string(bytes)
 		}
 
-		tasks = append(tasks, task)
+		id, _ := strconv.ParseInt(task.Id, 10, 64)
+		last = taskCursor{Sort: sort, Value: value, Id: id}
+
+		page.Tasks = append(page.Tasks, task)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over task rows")
+		return TaskPage{}, fmt.Errorf("error iterating over task rows")
 	}
 
-	return tasks, nil
+	return page, nil
+}
+
+// Agenda groups all tasks by the day they are due, as seen on the date
+// nowStr (today by default) in the timezone tz (the server's by default).
+// Tasks are overdue when they are due before that date, however they got
+// there.
+func (repository *TaskRepository) Agenda(nowStr, tz string) (Agenda, error) {
+	location := time.Local
+	if tz != "" {
+		var err error
+		location, err = time.LoadLocation(tz)
+		if err != nil {
+			return Agenda{}, fmt.Errorf("unknown timezone %q", tz)
+		}
+	}
+
+	now := time.Now().In(location)
+	if nowStr != "" {
+		var err error
+		now, err = time.Parse(utils.DateFormat, nowStr)
+		if err != nil {
+			return Agenda{}, fmt.Errorf("invalid now date format")
+		}
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	tomorrow := today.AddDate(0, 0, 1)
+	endOfWeek := today.AddDate(0, 0, 6-(int(today.Weekday())+6)%7)
+
+	agenda := Agenda{
+		Date:     today.Format(utils.DateFormat),
+		Timezone: location.String(),
+		Overdue:  make([]AgendaTask, 0),
+		Today:    make([]AgendaTask, 0),
+		Tomorrow: make([]AgendaTask, 0),
+		Week:     make([]AgendaTask, 0),
+		Later:    make([]AgendaTask, 0),
+	}
+
+	rows, err := repository.db.Query("SELECT "+taskColumns+" FROM scheduler WHERE owner_id = :owner ORDER BY starts_at, id",
+		sql.Named("owner", repository.owner))
+	if err != nil {
+		return Agenda{}, fmt.Errorf("error querying tasks from the database")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var task Task
+		err = rows.Scan(&task.Id, &task.Date, &task.Time, &task.Title, &task.Comment, &task.Repeat, &task.Skip, &task.Anchor, &task.Duration, &task.Timezone, &task.Priority)
+		if err != nil {
+			return Agenda{}, fmt.Errorf("error scanning task data")
+		}
+
+		day, err := task.dayIn(location)
+		if err != nil {
+			return Agenda{}, fmt.Errorf("invalid date of task %s", task.Id)
+		}
+
+		item := AgendaTask{Task: task}
+		switch {
+		case day.Before(today):
+			item.DaysLate = int(today.Sub(day).Hours() / 24)
+			agenda.Overdue = append(agenda.Overdue, item)
+		case day.Equal(today):
+			agenda.Today = append(agenda.Today, item)
+		case day.Equal(tomorrow):
+			agenda.Tomorrow = append(agenda.Tomorrow, item)
+		case !day.After(endOfWeek):
+			agenda.Week = append(agenda.Week, item)
+		default:
+			agenda.Later = append(agenda.Later, item)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return Agenda{}, fmt.Errorf("error iterating over task rows")
+	}
+
+	return agenda, nil
 }
 
 func (repository *TaskRepository) GetById(id int) (Task, error) {
-	row := repository.db.QueryRow("SELECT id, date, title, comment, repeat FROM scheduler WHERE id = :id", sql.Named("id", id))
+	row := repository.db.QueryRow("SELECT "+taskColumns+" FROM scheduler WHERE id = :id AND owner_id = :owner",
+		sql.Named("id", id),
+		sql.Named("owner", repository.owner))
 
 	task, err := convertSqlToTask(row)
 	if err != nil {
@@ -158,16 +546,93 @@ func (repository *TaskRepository) GetById(id int) (Task, error) {
 	return task, err
 }
 
-func (repository *TaskRepository) CalculateNextDate(nowStr, dateStr, repeat string) (string, error) {
+func (repository *TaskRepository) CalculateOccurrences(fromStr, dateStr, timeStr, repeat, skip, countStr string) ([]Occurrence, error) {
+	from := time.Now()
+	if fromStr != "" {
+		var err error
+		from, err = time.Parse(utils.DateFormat, fromStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from format")
+		}
+	}
+
+	if dateStr == "" {
+		dateStr = from.Format(utils.DateFormat)
+	}
+
+	count := 10
+	if countStr != "" {
+		var err error
+		count, err = strconv.Atoi(countStr)
+		if err != nil || count < 1 || count > maxOccurrenceCount {
+			return nil, fmt.Errorf("count must be between 1 and %d", maxOccurrenceCount)
+		}
+	}
+
+	occurrences := make([]Occurrence, 0, count)
+	fromDate := from.Format(utils.DateFormat)
+	if dateStr >= fromDate && !utils.IsSkipped(skip, dateStr) {
+		occurrences = append(occurrences, Occurrence{Date: dateStr, Time: timeStr})
+	}
+
+	// From is inclusive, and Advance only returns dates after now, so the
+	// search starts the day before.
+	now := from.AddDate(0, 0, -1)
+	for repeat != "" && len(occurrences) < count {
+		var err error
+		dateStr, timeStr, repeat, err = utils.Advance(now, dateStr, timeStr, repeat, skip)
+		if errors.Is(err, utils.ErrNoNextDate) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Timed rules can still fire later on the day before.
+		if dateStr >= fromDate {
+			occurrences = append(occurrences, Occurrence{Date: dateStr, Time: timeStr})
+		}
+
+		if timeStr == "" {
+			now, err = time.Parse(utils.DateFormat, dateStr)
+		} else {
+			now, err = time.Parse(utils.DateFormat+utils.TimeFormat, dateStr+timeStr)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid time format")
+		}
+	}
+
+	return occurrences, nil
+}
+
+func (repository *TaskRepository) CalculateNextDate(nowStr, dateStr, timeStr, repeat, skip, anchor string) (string, string, error) {
 	now, err := time.Parse(utils.DateFormat, nowStr)
 	if err != nil {
-		return "", fmt.Errorf("invalid now format")
+		now, err = time.Parse(utils.DateFormat+" "+utils.TimeFormat, nowStr)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid now format")
+		}
+	}
+
+	switch anchor {
+	case "", AnchorScheduled:
+	case AnchorCompletion:
+		dateStr = now.Format(utils.DateFormat)
+	default:
+		return "", "", fmt.Errorf("the anchor must be %q or %q", AnchorScheduled, AnchorCompletion)
 	}
 
-	nextDate, err := utils.NextDate(now, dateStr, repeat)
+	nextDate, nextTime, err := utils.NextDateTime(now, dateStr, timeStr, repeat, skip)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	return nextDate, nil
+	return nextDate, nextTime, nil
+}
+
+func prefixColumns(prefix, columns string) string {
+	return prefix + strings.ReplaceAll(columns, ", ", ", "+prefix)
 }
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// maxTOTPFailures is how many wrong TOTP codes in a row lock the codes of a
+// user, who then needs a recovery code, so that codes can't be guessed.
+const maxTOTPFailures = 10
+
+// TOTP is the two-factor authentication of a user. Secret and URI are only
+// set when enrolling, and RecoveryCodes when they are handed out; Code is
+// the TOTP or recovery code sent to confirm a change.
+type TOTP struct {
+	Enabled           bool     `json:"enabled"`
+	Secret            string   `json:"secret,omitempty"`
+	URI               string   `json:"uri,omitempty"`
+	RecoveryCodes     []string `json:"recovery_codes,omitempty"`
+	RecoveryCodesLeft int      `json:"recovery_codes_left"`
+	Code              string   `json:"code,omitempty"`
+}
+
+func GetTOTPFromBody(request *http.Request) (TOTP, error) {
+	var totp TOTP
+	var buffer bytes.Buffer
+
+	_, err := buffer.ReadFrom(request.Body)
+	if err != nil {
+		return TOTP{}, fmt.Errorf("error reading request body")
+	}
+
+	err = json.Unmarshal(buffer.Bytes(), &totp)
+	if err != nil {
+		return TOTP{}, fmt.Errorf("invalid JSON format")
+	}
+
+	return totp, nil
+}
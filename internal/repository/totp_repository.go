@@ -0,0 +1,315 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/capybara120404/todo-list/internal/auth"
+	"github.com/capybara120404/todo-list/internal/database"
+)
+
+// TOTPRepository keeps the TOTP secrets and recovery codes of one owner, or
+// checks the codes of anyone signing in.
+type TOTPRepository struct {
+	db    *sql.DB
+	owner int64
+}
+
+func NewTOTPRepository(connecter *database.Connecter) *TOTPRepository {
+	return &TOTPRepository{
+		db: connecter.DB,
+	}
+}
+
+// Owner returns a copy of the repository for the second factor of a user.
+func (repository *TOTPRepository) Owner(id int64) *TOTPRepository {
+	owned := *repository
+	owned.owner = id
+
+	return &owned
+}
+
+// Get returns whether two-factor authentication is on and how many
+// recovery codes are left.
+func (repository *TOTPRepository) Get() (TOTP, error) {
+	var totp TOTP
+	err := repository.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM totp WHERE user_id = :owner AND confirmed),
+		(SELECT count(*) FROM recovery_codes WHERE user_id = :owner)`,
+		sql.Named("owner", repository.owner)).Scan(&totp.Enabled, &totp.RecoveryCodesLeft)
+	if err != nil {
+		return TOTP{}, fmt.Errorf("error retrieving two-factor authentication from database")
+	}
+
+	return totp, nil
+}
+
+// Enrol starts turning two-factor authentication on with a new secret,
+// which Confirm finishes. Users from single sign-on have the second factor
+// of their identity provider instead.
+func (repository *TOTPRepository) Enrol() (TOTP, error) {
+	var external, enabled bool
+	var account string
+	err := repository.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM user_identities WHERE user_id = :owner),
+		EXISTS (SELECT 1 FROM totp WHERE user_id = :owner AND confirmed),
+		coalesce((SELECT login FROM users WHERE id = :owner), '')`,
+		sql.Named("owner", repository.owner)).Scan(&external, &enabled, &account)
+	if err != nil {
+		return TOTP{}, fmt.Errorf("error retrieving two-factor authentication from database")
+	}
+
+	if external {
+		return TOTP{}, fmt.Errorf("users from single sign-on use the second factor of their identity provider")
+	}
+
+	if enabled {
+		return TOTP{}, fmt.Errorf("two-factor authentication is already on")
+	}
+
+	// User 0 signs in with TODO_PASSWORD alone and has no login.
+	if account == "" {
+		account = "default"
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		return TOTP{}, err
+	}
+
+	_, err = repository.db.Exec(`INSERT INTO totp (user_id, secret) VALUES (:owner, :secret)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, last_step = 0, failures = 0 WHERE NOT confirmed`,
+		sql.Named("owner", repository.owner),
+		sql.Named("secret", secret))
+	if err != nil {
+		return TOTP{}, fmt.Errorf("error inserting data into the database")
+	}
+
+	return TOTP{Secret: secret, URI: auth.TOTPURI(account, secret)}, nil
+}
+
+// Confirm turns two-factor authentication on once the code of the secret
+// from Enrol is right, and returns the recovery codes, which can't be seen
+// again.
+func (repository *TOTPRepository) Confirm(code string) (TOTP, error) {
+	var secret string
+	err := repository.db.QueryRow("SELECT secret FROM totp WHERE user_id = :owner AND NOT confirmed",
+		sql.Named("owner", repository.owner)).Scan(&secret)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return TOTP{}, fmt.Errorf("two-factor authentication is not being enrolled")
+		}
+
+		return TOTP{}, fmt.Errorf("error retrieving two-factor authentication from database")
+	}
+
+	step, ok := auth.CheckTOTP(secret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return TOTP{}, fmt.Errorf("wrong two-factor code")
+	}
+
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return TOTP{}, fmt.Errorf("error turning two-factor authentication on")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE totp SET confirmed = 1, last_step = :step WHERE user_id = :owner",
+		sql.Named("step", step),
+		sql.Named("owner", repository.owner))
+	if err != nil {
+		return TOTP{}, fmt.Errorf("error turning two-factor authentication on")
+	}
+
+	codes, err := replaceRecoveryCodes(tx, repository.owner)
+	if err != nil {
+		return TOTP{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return TOTP{}, fmt.Errorf("error turning two-factor authentication on")
+	}
+
+	return TOTP{Enabled: true, RecoveryCodes: codes, RecoveryCodesLeft: len(codes)}, nil
+}
+
+// RecoveryCodes hands out new recovery codes in place of the old ones once
+// a code is right.
+func (repository *TOTPRepository) RecoveryCodes(code string) (TOTP, error) {
+	err := repository.check(repository.owner, code)
+	if err != nil {
+		return TOTP{}, err
+	}
+
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return TOTP{}, fmt.Errorf("error creating recovery codes")
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(tx, repository.owner)
+	if err != nil {
+		return TOTP{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return TOTP{}, fmt.Errorf("error creating recovery codes")
+	}
+
+	return TOTP{Enabled: true, RecoveryCodes: codes, RecoveryCodesLeft: len(codes)}, nil
+}
+
+// Disable turns two-factor authentication off once a code is right.
+func (repository *TOTPRepository) Disable(code string) error {
+	err := repository.check(repository.owner, code)
+	if err != nil {
+		return err
+	}
+
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error turning two-factor authentication off")
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"totp", "recovery_codes"} {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE user_id = :owner", sql.Named("owner", repository.owner))
+		if err != nil {
+			return fmt.Errorf("error turning two-factor authentication off")
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error turning two-factor authentication off")
+	}
+
+	return nil
+}
+
+// CheckSecondFactor returns nil if a user hasn't turned two-factor
+// authentication on, or if the code is right, and uses the code up. It
+// fails closed: when the second factor can't be looked up, the code is
+// wrong.
+func (repository *TOTPRepository) CheckSecondFactor(id int64, code string) error {
+	var enabled bool
+	err := repository.db.QueryRow("SELECT EXISTS (SELECT 1 FROM totp WHERE user_id = :id AND confirmed)",
+		sql.Named("id", id)).Scan(&enabled)
+	if err != nil {
+		return fmt.Errorf("error retrieving two-factor authentication from database")
+	}
+
+	if !enabled {
+		return nil
+	}
+
+	return repository.check(id, code)
+}
+
+// check checks the TOTP code, or a recovery code, of a user with two-factor
+// authentication on. A TOTP code works once, and a recovery code is
+// deleted when used.
+func (repository *TOTPRepository) check(id int64, code string) error {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return fmt.Errorf("the two-factor code is required")
+	}
+
+	var secret string
+	err := repository.db.QueryRow("SELECT secret FROM totp WHERE user_id = :id AND confirmed",
+		sql.Named("id", id)).Scan(&secret)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("two-factor authentication is off")
+		}
+
+		return fmt.Errorf("error retrieving two-factor authentication from database")
+	}
+
+	if !auth.IsTOTPCode(code) {
+		res, err := repository.db.Exec("DELETE FROM recovery_codes WHERE user_id = :id AND hash = :hash",
+			sql.Named("id", id),
+			sql.Named("hash", auth.HashRecoveryCode(code)))
+		if err != nil {
+			return fmt.Errorf("error checking the recovery code")
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil || rowsAffected == 0 {
+			return fmt.Errorf("wrong two-factor code")
+		}
+
+		_, err = repository.db.Exec("UPDATE totp SET failures = 0 WHERE user_id = :id", sql.Named("id", id))
+		if err != nil {
+			return fmt.Errorf("error checking the recovery code")
+		}
+
+		return nil
+	}
+
+	// The attempt is counted before the code is checked, in one statement,
+	// so that sign-ins in parallel can't all get in under the limit. A
+	// right code resets the count.
+	var failures int
+	err = repository.db.QueryRow("UPDATE totp SET failures = failures + 1 WHERE user_id = :id AND failures < :max RETURNING failures",
+		sql.Named("id", id),
+		sql.Named("max", maxTOTPFailures)).Scan(&failures)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("too many wrong two-factor codes, use a recovery code")
+		}
+
+		return fmt.Errorf("error checking the two-factor code")
+	}
+
+	step, ok := auth.CheckTOTP(secret, code, time.Now())
+	if !ok {
+		return fmt.Errorf("wrong two-factor code")
+	}
+
+	// The step only moves forward, so a code can't be used again.
+	res, err := repository.db.Exec("UPDATE totp SET last_step = :step, failures = 0 WHERE user_id = :id AND last_step < :step",
+		sql.Named("step", step),
+		sql.Named("id", id))
+	if err != nil {
+		return fmt.Errorf("error checking the two-factor code")
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error retrieving affected rows")
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("the two-factor code was already used")
+	}
+
+	return nil
+}
+
+// replaceRecoveryCodes stores the hashes of new recovery codes of a user in
+// place of the old ones and returns the codes.
+func replaceRecoveryCodes(tx *sql.Tx, id int64) ([]string, error) {
+	codes, hashes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = :id", sql.Named("id", id))
+	if err != nil {
+		return nil, fmt.Errorf("error creating recovery codes")
+	}
+
+	for _, hash := range hashes {
+		_, err = tx.Exec("INSERT INTO recovery_codes (user_id, hash) VALUES (:id, :hash)",
+			sql.Named("id", id),
+			sql.Named("hash", hash))
+		if err != nil {
+			return nil, fmt.Errorf("error creating recovery codes")
+		}
+	}
+
+	return codes, nil
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	minLoginLength    = 3
+	maxLoginLength    = 64
+	minPasswordLength = 8
+	// bcrypt only uses the first 72 bytes of a password.
+	maxPasswordLength = 72
+)
+
+type User struct {
+	Id       int64  `json:"id"`
+	Login    string `json:"login"`
+	Password string `json:"password,omitempty"`
+	// Code is the TOTP or recovery code of users with two-factor
+	// authentication, when signing in.
+	Code string `json:"code,omitempty"`
+}
+
+func GetUserFromBody(request *http.Request) (User, error) {
+	var user User
+	var buffer bytes.Buffer
+
+	_, err := buffer.ReadFrom(request.Body)
+	if err != nil {
+		return User{}, fmt.Errorf("error reading request body")
+	}
+
+	err = json.Unmarshal(buffer.Bytes(), &user)
+	if err != nil {
+		return User{}, fmt.Errorf("invalid JSON format")
+	}
+
+	return user, nil
+}
+
+// normalizeLogin makes logins match in any case and with stray spaces.
+func normalizeLogin(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
+}
+
+func isCorrectLogin(login string) error {
+	if len(login) < minLoginLength || len(login) > maxLoginLength {
+		return fmt.Errorf("the login must be %d to %d characters long", minLoginLength, maxLoginLength)
+	}
+
+	for _, r := range login {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || strings.ContainsRune("._@-", r)) {
+			return fmt.Errorf("the login may only contain Latin letters, digits and . _ @ -")
+		}
+	}
+
+	return nil
+}
+
+func isCorrectUser(user *User) error {
+	user.Login = normalizeLogin(user.Login)
+	err := isCorrectLogin(user.Login)
+	if err != nil {
+		return err
+	}
+
+	if len(user.Password) < minPasswordLength {
+		return fmt.Errorf("the password must be at least %d characters long", minPasswordLength)
+	}
+
+	if len(user.Password) > maxPasswordLength {
+		return fmt.Errorf("the password must not be longer than %d bytes", maxPasswordLength)
+	}
+
+	return nil
+}
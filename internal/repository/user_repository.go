@@ -0,0 +1,258 @@
+package repository
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/capybara120404/todo-list/internal/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is compared against when a login doesn't exist, so that a
+// wrong login takes as long to reject as a wrong password.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+type UserRepository struct {
+	db *sql.DB
+}
+
+func NewUserRepository(connecter *database.Connecter) *UserRepository {
+	return &UserRepository{
+		db: connecter.DB,
+	}
+}
+
+// Add registers a user with a bcrypt hash of the password. When adopt is
+// set, the first user takes over the tasks and lists of user 0, which were
+// created before there were users.
+func (repository *UserRepository) Add(user *User, adopt bool) (int64, error) {
+	err := isCorrectUser(user)
+	if err != nil {
+		return 0, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, fmt.Errorf("error hashing the password")
+	}
+
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error adding the user")
+	}
+	defer tx.Rollback()
+
+	id, err := insertUser(tx, user.Login, hash, adopt)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("error adding the user")
+	}
+
+	user.Id = id
+	user.Password = ""
+
+	return id, nil
+}
+
+// External returns the Id of the user an identity provider signed in as
+// subject, registering them the first time, like Add. They get the first
+// of logins that is valid and free, or else one such as "oidc-7", so that
+// what the provider calls them never keeps them out. Their password is
+// random, so they can only sign in through the provider.
+func (repository *UserRepository) External(issuer, subject string, logins []string, adopt bool) (int64, error) {
+	var id int64
+	err := repository.db.QueryRow("SELECT user_id FROM user_identities WHERE issuer = :issuer AND subject = :subject",
+		sql.Named("issuer", issuer),
+		sql.Named("subject", subject)).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+
+	if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("error retrieving user from database")
+	}
+
+	password := make([]byte, 32)
+	_, err = rand.Read(password)
+	if err != nil {
+		return 0, fmt.Errorf("error creating the password")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(password)), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, fmt.Errorf("error hashing the password")
+	}
+
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error adding the user")
+	}
+	defer tx.Rollback()
+
+	login, err := freeLogin(tx, logins)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err = insertUser(tx, login, hash, adopt)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("INSERT INTO user_identities (issuer, subject, user_id) VALUES (:issuer, :subject, :id)",
+		sql.Named("issuer", issuer),
+		sql.Named("subject", subject),
+		sql.Named("id", id))
+	if err != nil {
+		return 0, fmt.Errorf("error adding the user")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("error adding the user")
+	}
+
+	return id, nil
+}
+
+// freeLogin returns the first of logins that is valid and not taken, or
+// else "oidc-" and a number that is free.
+func freeLogin(tx *sql.Tx, logins []string) (string, error) {
+	taken := func(login string) (bool, error) {
+		var exists bool
+		err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE login = :login)", sql.Named("login", login)).Scan(&exists)
+		if err != nil {
+			return false, fmt.Errorf("error retrieving user from database")
+		}
+
+		return exists, nil
+	}
+
+	for _, login := range logins {
+		login = normalizeLogin(login)
+		if isCorrectLogin(login) != nil {
+			continue
+		}
+
+		exists, err := taken(login)
+		if err != nil {
+			return "", err
+		}
+
+		if !exists {
+			return login, nil
+		}
+	}
+
+	var n int64
+	err := tx.QueryRow("SELECT coalesce(max(id), 0) + 1 FROM users").Scan(&n)
+	if err != nil {
+		return "", fmt.Errorf("error retrieving user from database")
+	}
+
+	for ; ; n++ {
+		login := fmt.Sprintf("oidc-%d", n)
+		exists, err := taken(login)
+		if err != nil {
+			return "", err
+		}
+
+		if !exists {
+			return login, nil
+		}
+	}
+}
+
+// insertUser adds a user with a password hash and, when adopt is set and
+// they are the first user, hands the tasks and lists of user 0 over to them.
+func insertUser(tx *sql.Tx, login string, hash []byte, adopt bool) (int64, error) {
+	var first bool
+	err := tx.QueryRow("SELECT NOT EXISTS (SELECT 1 FROM users)").Scan(&first)
+	if err != nil {
+		return 0, fmt.Errorf("error adding the user")
+	}
+
+	res, err := tx.Exec("INSERT INTO users (login, password) VALUES (:login, :password)",
+		sql.Named("login", login),
+		sql.Named("password", string(hash)))
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return 0, fmt.Errorf("the login %q is taken", login)
+		}
+
+		return 0, fmt.Errorf("error adding the user")
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error retrieving last insert Id")
+	}
+
+	if adopt && first {
+		for _, table := range []string{"scheduler", "lists"} {
+			_, err = tx.Exec("UPDATE "+table+" SET owner_id = :id WHERE owner_id = 0", sql.Named("id", id))
+			if err != nil {
+				return 0, fmt.Errorf("error handing over the tasks")
+			}
+		}
+	}
+
+	return id, nil
+}
+
+// Any reports whether there are users.
+func (repository *UserRepository) Any() (bool, error) {
+	var exists bool
+	err := repository.db.QueryRow("SELECT EXISTS (SELECT 1 FROM users)").Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error querying users from the database")
+	}
+
+	return exists, nil
+}
+
+// Check returns the Id and password hash of a user if the password is right.
+func (repository *UserRepository) Check(login, password string) (int64, string, error) {
+	var id int64
+	var hash string
+	err := repository.db.QueryRow("SELECT id, password FROM users WHERE login = :login",
+		sql.Named("login", normalizeLogin(login))).Scan(&id, &hash)
+	if err == sql.ErrNoRows {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return 0, "", fmt.Errorf("wrong login or password")
+	}
+
+	if err != nil {
+		return 0, "", fmt.Errorf("error retrieving user from database")
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil {
+		return 0, "", fmt.Errorf("wrong login or password")
+	}
+
+	return id, hash, nil
+}
+
+// PasswordHash returns the password hash of a user, which their tokens are
+// signed with.
+func (repository *UserRepository) PasswordHash(id int64) (string, error) {
+	var hash string
+	err := repository.db.QueryRow("SELECT password FROM users WHERE id = :id", sql.Named("id", id)).Scan(&hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("user not found")
+		}
+
+		return "", fmt.Errorf("error retrieving user from database")
+	}
+
+	return hash, nil
+}
@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonthNames = map[string]int{
+	"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+	"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+}
+
+var cronWeekdayNames = map[string]int{
+	"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+}
+
+type cronField struct {
+	values map[int]bool
+	any    bool
+}
+
+type cronSchedule struct {
+	expression string
+	minute     cronField
+	hour       cronField
+	dayOfMonth cronField
+	month      cronField
+	dayOfWeek  cronField
+}
+
+func isCron(repeat string) bool {
+	return strings.HasPrefix(repeat, "@") || len(strings.Fields(repeat)) == 5
+}
+
+func parseCronRule(rule string) (Rule, error) {
+	return parseCron(rule)
+}
+
+// parseCron parses a cron expression or a macro such as "@daily". The
+// schedule keeps the text as written, the macro rather than its expansion.
+func parseCron(repeat string) (cronSchedule, error) {
+	fields := strings.Fields(repeat)
+	if strings.HasPrefix(repeat, "@") {
+		macro, ok := cronMacros[repeat]
+		if !ok {
+			return cronSchedule{}, fmt.Errorf("unsupported cron macro %q", repeat)
+		}
+
+		fields = strings.Fields(macro)
+	}
+
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("a cron expression must have 5 fields")
+	}
+
+	schedule := cronSchedule{expression: repeat}
+	var err error
+
+	schedule.minute, err = parseCronField(fields[0], 0, 59, nil)
+	if err != nil {
+		return cronSchedule{}, fmt.Errorf("invalid cron minute: %v", err)
+	}
+
+	schedule.hour, err = parseCronField(fields[1], 0, 23, nil)
+	if err != nil {
+		return cronSchedule{}, fmt.Errorf("invalid cron hour: %v", err)
+	}
+
+	schedule.dayOfMonth, err = parseCronField(fields[2], 1, 31, nil)
+	if err != nil {
+		return cronSchedule{}, fmt.Errorf("invalid cron day of the month: %v", err)
+	}
+
+	schedule.month, err = parseCronField(fields[3], 1, 12, cronMonthNames)
+	if err != nil {
+		return cronSchedule{}, fmt.Errorf("invalid cron month: %v", err)
+	}
+
+	schedule.dayOfWeek, err = parseCronField(fields[4], 0, 7, cronWeekdayNames)
+	if err != nil {
+		return cronSchedule{}, fmt.Errorf("invalid cron day of the week: %v", err)
+	}
+
+	if schedule.dayOfWeek.values[7] {
+		schedule.dayOfWeek.values[0] = true
+	}
+
+	return schedule, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (cronField, error) {
+	result := cronField{values: make(map[int]bool), any: field == "*"}
+
+	for _, item := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return cronField{}, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+
+		var low, high int
+		if rangePart == "*" {
+			low, high = min, max
+		} else {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+
+			var err error
+			low, err = parseCronValue(lowPart, min, max, names)
+			if err != nil {
+				return cronField{}, err
+			}
+
+			high = low
+			if isRange {
+				high, err = parseCronValue(highPart, min, max, names)
+				if err != nil {
+					return cronField{}, err
+				}
+			} else if hasStep {
+				high = max
+			}
+
+			if high < low {
+				return cronField{}, fmt.Errorf("invalid range %q", rangePart)
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			result.values[value] = true
+		}
+	}
+
+	return result, nil
+}
+
+func parseCronValue(value string, min, max int, names map[string]int) (int, error) {
+	if number, ok := names[strings.ToUpper(value)]; ok {
+		return number, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min || number > max {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+
+	return number, nil
+}
+
+func (schedule cronSchedule) matchesDay(date time.Time) bool {
+	dayOfMonth := schedule.dayOfMonth.values[date.Day()]
+	dayOfWeek := schedule.dayOfWeek.values[int(date.Weekday())]
+
+	// As in Vixie cron, a day matches either field when both are restricted.
+	if !schedule.dayOfMonth.any && !schedule.dayOfWeek.any {
+		return dayOfMonth || dayOfWeek
+	}
+
+	return dayOfMonth && dayOfWeek
+}
+
+func (schedule cronSchedule) next(after time.Time) (time.Time, error) {
+	next := after.Truncate(time.Minute).Add(time.Minute)
+	limit := next.AddDate(maxMonthSearch/12, 0, 0)
+
+	for next.Before(limit) {
+		if !schedule.month.values[int(next.Month())] {
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if !schedule.matchesDay(next) {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if !schedule.hour.values[next.Hour()] {
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if !schedule.minute.values[next.Minute()] {
+			next = next.Add(time.Minute)
+			continue
+		}
+
+		return next, nil
+	}
+
+	return time.Time{}, fmt.Errorf("the cron expression never matches a date")
+}
+
+func (schedule cronSchedule) Next(start, now time.Time) (time.Time, error) {
+	after := start
+	if wall := wallClock(now); wall.After(after) {
+		after = wall
+	}
+
+	return schedule.next(after)
+}
+
+func (schedule cronSchedule) Describe() string {
+	return schedule.DescribeIn(LangEnglish)
+}
+
+func (schedule cronSchedule) DescribeIn(lang string) string {
+	if lang == LangRussian {
+		return "по расписанию cron " + schedule.expression
+	}
+
+	return "on the cron schedule " + schedule.expression
+}
+
+// wallClock drops the location of t, so that it can be compared with task
+// dates and times, which are stored as plain local values.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
@@ -0,0 +1,273 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Languages repeat rules can be described in. English is the default.
+const (
+	LangEnglish = "en"
+	LangRussian = "ru"
+)
+
+var ruWeekdays = map[time.Weekday]string{
+	time.Monday:    "понедельникам",
+	time.Tuesday:   "вторникам",
+	time.Wednesday: "средам",
+	time.Thursday:  "четвергам",
+	time.Friday:    "пятницам",
+	time.Saturday:  "субботам",
+	time.Sunday:    "воскресеньям",
+}
+
+// ruWeekdaysAccusative holds the name of each day of the week after "в",
+// with the ending an ordinal takes before it.
+var ruWeekdaysAccusative = map[time.Weekday]struct{ name, ending, last string }{
+	time.Monday:    {"понедельник", "й", "последний"},
+	time.Tuesday:   {"вторник", "й", "последний"},
+	time.Wednesday: {"среду", "ю", "последнюю"},
+	time.Thursday:  {"четверг", "й", "последний"},
+	time.Friday:    {"пятницу", "ю", "последнюю"},
+	time.Saturday:  {"субботу", "ю", "последнюю"},
+	time.Sunday:    {"воскресенье", "е", "последнее"},
+}
+
+var ruMonths = map[time.Month]string{
+	time.January:   "январе",
+	time.February:  "феврале",
+	time.March:     "марте",
+	time.April:     "апреле",
+	time.May:       "мае",
+	time.June:      "июне",
+	time.July:      "июле",
+	time.August:    "августе",
+	time.September: "сентябре",
+	time.October:   "октябре",
+	time.November:  "ноябре",
+	time.December:  "декабре",
+}
+
+// Language picks the language to describe repeat rules in from an
+// Accept-Language header, honouring q-values.
+func Language(acceptLanguage string) string {
+	best, bestWeight := LangEnglish, 0.0
+
+	for _, item := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		tag, _, _ = strings.Cut(strings.ToLower(tag), "-")
+
+		weight := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+
+			weight = parsed
+		}
+
+		if (tag == LangEnglish || tag == LangRussian) && weight > bestWeight {
+			best, bestWeight = tag, weight
+		}
+	}
+
+	return best
+}
+
+// DescribeRepeat returns a repeat rule, modifiers included, in words.
+func DescribeRepeat(repeat, lang string) (string, error) {
+	ruleStr, mods, err := parseModifiers(repeat)
+	if err != nil {
+		return "", err
+	}
+
+	_, rule, err := parseRule(ruleStr)
+	if err != nil {
+		return "", err
+	}
+
+	text := rule.Describe()
+	if localized, ok := rule.(LocalizedRule); ok {
+		text = localized.DescribeIn(lang)
+	}
+
+	return text + mods.describe(lang), nil
+}
+
+func (mods modifiers) describe(lang string) string {
+	var result string
+
+	if mods.count > 0 {
+		if lang == LangRussian {
+			result += fmt.Sprintf(", %d %s", mods.count, ruPlural(mods.count, "раз", "раза", "раз"))
+		} else if mods.count == 1 {
+			result += ", once"
+		} else {
+			result += fmt.Sprintf(", %d times", mods.count)
+		}
+	}
+
+	if mods.until != "" {
+		result += describeUntil(mods.until, lang)
+	}
+
+	switch {
+	case mods.shift == 1 && lang == LangRussian:
+		result += ", с переносом на следующий рабочий день"
+	case mods.shift == -1 && lang == LangRussian:
+		result += ", с переносом на предыдущий рабочий день"
+	case mods.shift == 1:
+		result += ", moved to the next workday"
+	case mods.shift == -1:
+		result += ", moved to the previous workday"
+	}
+
+	return result
+}
+
+func describeUntil(until, lang string) string {
+	date, err := time.Parse(DateFormat, until)
+	if err != nil {
+		return ""
+	}
+
+	if lang == LangRussian {
+		return ", до " + date.Format("02.01.2006")
+	}
+
+	return ", until " + date.Format("January 2, 2006")
+}
+
+// ruPlural picks the form of a Russian noun that goes after number, e.g.
+// 1 день, 2 дня, 5 дней.
+func ruPlural(number int, one, few, many string) string {
+	if number < 0 {
+		number = -number
+	}
+
+	switch {
+	case number%10 == 1 && number%100 != 11:
+		return one
+	case number%10 >= 2 && number%10 <= 4 && (number%100 < 12 || number%100 > 14):
+		return few
+	default:
+		return many
+	}
+}
+
+// ruEvery says "every N units" in Russian. The forms are those of the unit in
+// the accusative case, e.g. неделю, недели, недель.
+func ruEvery(number int, single, one, few, many string) string {
+	switch {
+	case number == 1:
+		return single
+	case number%10 == 1 && number%100 != 11:
+		return fmt.Sprintf("%s %d %s", strings.Fields(single)[0], number, one)
+	default:
+		return fmt.Sprintf("каждые %d %s", number, ruPlural(number, one, few, many))
+	}
+}
+
+func describeWeekdays(weekdays []time.Weekday, lang string) string {
+	var names []string
+	for _, day := range weekdays {
+		if lang == LangRussian {
+			names = append(names, ruWeekdays[day])
+		} else {
+			names = append(names, day.String())
+		}
+	}
+
+	if lang == LangRussian {
+		return "по " + strings.Join(names, ", ")
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func describeMonths(months map[time.Month]bool, lang string) string {
+	var names []string
+	for month := time.January; month <= time.December; month++ {
+		if !months[month] {
+			continue
+		}
+
+		if lang == LangRussian {
+			names = append(names, ruMonths[month])
+		} else {
+			names = append(names, month.String())
+		}
+	}
+
+	if lang == LangRussian {
+		return "в " + strings.Join(names, ", ")
+	}
+
+	return "in " + strings.Join(names, ", ")
+}
+
+func describeMonthDays(days []int, lang string) string {
+	var names []string
+	for _, day := range days {
+		switch {
+		case day == -1 && lang == LangRussian:
+			names = append(names, "в последний день")
+		case day == -2 && lang == LangRussian:
+			names = append(names, "в предпоследний день")
+		case lang == LangRussian:
+			names = append(names, fmt.Sprintf("%d-го числа", day))
+		case day == -1:
+			names = append(names, "the last day")
+		case day == -2:
+			names = append(names, "the second-to-last day")
+		default:
+			names = append(names, "day "+strconv.Itoa(day))
+		}
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func describeOrdinal(number int) string {
+	switch {
+	case number == -1:
+		return "last"
+	case number < 0:
+		return describeOrdinal(-number) + " to last"
+	case number%10 == 1 && number%100 != 11:
+		return fmt.Sprintf("%dst", number)
+	case number%10 == 2 && number%100 != 12:
+		return fmt.Sprintf("%dnd", number)
+	case number%10 == 3 && number%100 != 13:
+		return fmt.Sprintf("%drd", number)
+	default:
+		return fmt.Sprintf("%dth", number)
+	}
+}
+
+func describeWeekdayNum(day weekdayNum, lang string) string {
+	if lang != LangRussian {
+		if day.ordinal == 0 {
+			return day.weekday.String()
+		}
+
+		return "the " + describeOrdinal(day.ordinal) + " " + day.weekday.String()
+	}
+
+	if day.ordinal == 0 {
+		return ruWeekdays[day.weekday]
+	}
+
+	forms := ruWeekdaysAccusative[day.weekday]
+	switch {
+	case day.ordinal == -1:
+		return forms.last + " " + forms.name
+	case day.ordinal < 0:
+		return fmt.Sprintf("%d-%s с конца %s", -day.ordinal, forms.ending, forms.name)
+	default:
+		return fmt.Sprintf("%d-%s %s", day.ordinal, forms.ending, forms.name)
+	}
+}
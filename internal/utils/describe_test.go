@@ -0,0 +1,66 @@
+package utils
+
+import "testing"
+
+func TestDescribeRepeat(t *testing.T) {
+	tbl := []struct {
+		repeat string
+		en     string
+		ru     string
+	}{
+		{"d 1", "every day", "каждый день"},
+		{"d 7", "every 7 days", "каждые 7 дней"},
+		{"d 3", "every 3 days", "каждые 3 дня"},
+		{"d 21", "every 21 days", "каждый 21 день"},
+		{"y", "every year", "ежегодно"},
+		{"w 1,4", "every Monday, Thursday", "по понедельникам, четвергам"},
+		{"m -1", "on the last day of every month", "в последний день каждого месяца"},
+		{"m 1,15 3", "on day 1, day 15 in March", "1-го числа, 15-го числа в марте"},
+		{"d 2 count 5", "every 2 days, 5 times", "каждые 2 дня, 5 раз"},
+		{"w 5 until 20241231 workday-", "every Friday, until December 31, 2024, moved to the previous workday",
+			"по пятницам, до 31.12.2024, с переносом на предыдущий рабочий день"},
+		{"RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=FR", "every 2 weeks on Friday", "каждые 2 недели по пятницам"},
+		{"RRULE:FREQ=MONTHLY;BYDAY=-1FR", "every month on the last Friday", "каждый месяц в последнюю пятницу"},
+		{"RRULE:FREQ=YEARLY;BYMONTH=5;BYDAY=2SU", "every year on the 2nd Sunday in May", "каждый год в 2-е воскресенье в мае"},
+		{"0 9 * * 1-5", "on the cron schedule 0 9 * * 1-5", "по расписанию cron 0 9 * * 1-5"},
+		{"@daily", "on the cron schedule @daily", "по расписанию cron @daily"},
+	}
+	for _, v := range tbl {
+		for lang, want := range map[string]string{LangEnglish: v.en, LangRussian: v.ru} {
+			got, err := DescribeRepeat(v.repeat, lang)
+			if err != nil {
+				t.Errorf("DescribeRepeat(%q, %s) returned %v", v.repeat, lang, err)
+				continue
+			}
+
+			if got != want {
+				t.Errorf("DescribeRepeat(%q, %s) = %q, want %q", v.repeat, lang, got, want)
+			}
+		}
+	}
+
+	_, err := DescribeRepeat("ooops", LangEnglish)
+	if err == nil {
+		t.Errorf("DescribeRepeat accepted an invalid rule")
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	tbl := []struct {
+		header string
+		want   string
+	}{
+		{"", LangEnglish},
+		{"ru", LangRussian},
+		{"ru-RU,ru;q=0.9,en-US;q=0.8", LangRussian},
+		{"de-DE,en;q=0.5,ru;q=0.7", LangRussian},
+		{"en-GB,ru;q=0.3", LangEnglish},
+		{"fr", LangEnglish},
+	}
+	for _, v := range tbl {
+		got := Language(v.header)
+		if got != v.want {
+			t.Errorf("Language(%q) = %s, want %s", v.header, got, v.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var holidays = make(map[string]bool)
+
+// LoadHolidays reads the days off used by the "workday" modifier from an
+// iCalendar (.ics) file with one VEVENT per holiday, or from a CSV file whose
+// first column holds dates as YYYYMMDD or YYYY-MM-DD.
+func LoadHolidays(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening holidays file: %v", err)
+	}
+	defer file.Close()
+
+	var dates map[string]bool
+	if strings.EqualFold(filepath.Ext(path), ".ics") {
+		dates, err = parseICSHolidays(file)
+	} else {
+		dates, err = parseCSVHolidays(file)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error reading holidays file: %v", err)
+	}
+
+	holidays = dates
+	return nil
+}
+
+func IsWorkday(date time.Time) bool {
+	if date.Weekday() == time.Saturday || date.Weekday() == time.Sunday {
+		return false
+	}
+
+	return !holidays[date.Format(DateFormat)]
+}
+
+func shiftToWorkday(date string, shift int) (string, error) {
+	day, err := time.Parse(DateFormat, date)
+	if err != nil {
+		return "", fmt.Errorf("invalid date format")
+	}
+
+	for !IsWorkday(day) {
+		day = day.AddDate(0, 0, shift)
+	}
+
+	return day.Format(DateFormat), nil
+}
+
+func parseCSVHolidays(reader io.Reader) (map[string]bool, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.Comment = '#'
+
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	dates := make(map[string]bool)
+	for i, record := range records {
+		value := strings.TrimSpace(record[0])
+
+		date, err := time.Parse(DateFormat, value)
+		if err != nil {
+			date, err = time.Parse("2006-01-02", value)
+		}
+
+		if err != nil {
+			// The first line may be a header.
+			if i == 0 {
+				continue
+			}
+
+			return nil, fmt.Errorf("invalid date %q on line %d", value, i+1)
+		}
+
+		dates[date.Format(DateFormat)] = true
+	}
+
+	return dates, nil
+}
+
+func parseICSHolidays(reader io.Reader) (map[string]bool, error) {
+	dates := make(map[string]bool)
+	scanner := bufio.NewScanner(reader)
+
+	var start, end string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+
+		name, _, _ = strings.Cut(name, ";")
+		switch name {
+		case "BEGIN":
+			if value == "VEVENT" {
+				start, end = "", ""
+			}
+		case "DTSTART":
+			start = value
+		case "DTEND":
+			end = value
+		case "END":
+			if value != "VEVENT" {
+				continue
+			}
+
+			err := addICSEvent(dates, start, end)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return dates, nil
+}
+
+func addICSEvent(dates map[string]bool, start, end string) error {
+	if len(start) < len(DateFormat) {
+		return fmt.Errorf("invalid event start %q", start)
+	}
+
+	first, err := time.Parse(DateFormat, start[:len(DateFormat)])
+	if err != nil {
+		return fmt.Errorf("invalid event start %q", start)
+	}
+
+	// DTEND of an all-day event is exclusive, a missing one means one day.
+	last := first
+	if len(end) >= len(DateFormat) {
+		last, err = time.Parse(DateFormat, end[:len(DateFormat)])
+		if err != nil {
+			return fmt.Errorf("invalid event end %q", end)
+		}
+
+		if len(end) == len(DateFormat) && last.After(first) {
+			last = last.AddDate(0, 0, -1)
+		}
+	}
+
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		dates[day.Format(DateFormat)] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,559 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var phraseWeekdays = map[string]time.Weekday{
+	"monday": time.Monday, "mondays": time.Monday, "mon": time.Monday, "пн": time.Monday,
+	"tuesday": time.Tuesday, "tuesdays": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday, "вт": time.Tuesday,
+	"wednesday": time.Wednesday, "wednesdays": time.Wednesday, "wed": time.Wednesday, "ср": time.Wednesday,
+	"thursday": time.Thursday, "thursdays": time.Thursday, "thu": time.Thursday, "thurs": time.Thursday, "чт": time.Thursday,
+	"friday": time.Friday, "fridays": time.Friday, "fri": time.Friday, "пт": time.Friday,
+	"saturday": time.Saturday, "saturdays": time.Saturday, "sat": time.Saturday, "сб": time.Saturday,
+	"sunday": time.Sunday, "sundays": time.Sunday, "sun": time.Sunday, "вс": time.Sunday,
+}
+
+// ruWeekdayStems match any case of a Russian day of the week.
+var ruWeekdayStems = map[string]time.Weekday{
+	"понедельн": time.Monday,
+	"вторник":   time.Tuesday,
+	"сред":      time.Wednesday,
+	"четверг":   time.Thursday,
+	"пятниц":    time.Friday,
+	"суббот":    time.Saturday,
+	"воскресен": time.Sunday,
+}
+
+var phraseMonths = map[string]time.Month{
+	"january": time.January, "jan": time.January,
+	"february": time.February, "feb": time.February,
+	"march": time.March, "mar": time.March,
+	"april": time.April, "apr": time.April,
+	"may": time.May, "май": time.May, "мая": time.May, "мае": time.May,
+	"june": time.June, "jun": time.June,
+	"july": time.July, "jul": time.July,
+	"august": time.August, "aug": time.August,
+	"september": time.September, "sep": time.September, "sept": time.September,
+	"october": time.October, "oct": time.October,
+	"november": time.November, "nov": time.November,
+	"december": time.December, "dec": time.December,
+}
+
+var ruMonthStems = map[string]time.Month{
+	"январ":   time.January,
+	"феврал":  time.February,
+	"март":    time.March,
+	"апрел":   time.April,
+	"июн":     time.June,
+	"июл":     time.July,
+	"август":  time.August,
+	"сентябр": time.September,
+	"октябр":  time.October,
+	"ноябр":   time.November,
+	"декабр":  time.December,
+}
+
+var phraseUnits = map[string]string{
+	"day": "day", "days": "day", "день": "day", "дня": "day", "дней": "day",
+	"week": "week", "weeks": "week", "неделя": "week", "неделю": "week", "недели": "week", "недель": "week",
+	"month": "month", "months": "month", "месяц": "month", "месяца": "month", "месяцев": "month",
+	"year": "year", "years": "year", "год": "year", "года": "year", "лет": "year",
+}
+
+var phraseAdverbs = map[string]string{
+	"daily": "day", "ежедневно": "day",
+	"weekly": "week", "еженедельно": "week",
+	"monthly": "month", "ежемесячно": "month",
+	"yearly": "year", "annually": "year", "ежегодно": "year",
+}
+
+var phraseOrdinals = map[string]int{
+	"first": 1, "second": 2, "third": 3, "fourth": 4, "fifth": 5, "last": -1,
+	"первый": 1, "первую": 1, "первое": 1, "первая": 1, "первого": 1,
+	"второй": 2, "вторую": 2, "второе": 2, "вторая": 2, "второго": 2,
+	"третий": 3, "третью": 3, "третье": 3, "третья": 3, "третьего": 3,
+	"четвертый": 4, "четвертую": 4, "четвертое": 4, "четвертая": 4, "четвертого": 4,
+	"пятый": 5, "пятую": 5, "пятое": 5, "пятая": 5, "пятого": 5,
+	"последний": -1, "последнюю": -1, "последнее": -1, "последняя": -1, "последнего": -1,
+	"предпоследний": -2, "предпоследнюю": -2, "предпоследнее": -2, "предпоследняя": -2, "предпоследнего": -2,
+}
+
+var phraseWorkdays = map[string]bool{
+	"weekday": true, "weekdays": true, "workday": true, "workdays": true,
+	"будни": true, "будням": true, "рабочим": true, "рабочие": true,
+}
+
+var phraseWeekends = map[string]bool{
+	"weekend": true, "weekends": true, "выходные": true, "выходным": true,
+}
+
+var phraseEvery = map[string]bool{
+	"every": true, "each": true,
+	"каждый": true, "каждую": true, "каждое": true, "каждые": true, "каждого": true, "каждой": true,
+}
+
+var phraseFillers = map[string]bool{
+	"the": true, "of": true, "on": true, "a": true, "an": true, "and": true, "in": true, "for": true,
+	"и": true, "в": true, "во": true, "по": true, "дням": true,
+}
+
+var phraseUntil = map[string]bool{"until": true, "till": true, "до": true}
+
+var phraseTimes = map[string]bool{"times": true, "occurrences": true, "раз": true, "раза": true}
+
+var phraseDateLayouts = []string{DateFormat, "2006-01-02", "02.01.2006", "2.1.2006"}
+
+// phrase is what the words of a phrase add up to.
+type phrase struct {
+	every     bool
+	other     bool
+	dayMarker bool
+	workdays  bool
+	weekends  bool
+	units     []string
+	interval  int
+	unit      string
+	weekdays  []weekdayNum
+	numbers   []int
+	monthDays []int
+	months    map[time.Month]bool
+	mods      modifiers
+}
+
+// ParseRepeatPhrase turns a phrase such as "every other Friday", "каждый
+// понедельник" or "last day of the month" into a repeat rule, e.g.
+// "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=FR", "w 1" or "m -1".
+func ParseRepeatPhrase(text string) (string, error) {
+	words := splitPhrase(text)
+	if len(words) == 0 {
+		return "", fmt.Errorf("empty phrase")
+	}
+
+	parsed, err := parsePhrase(words)
+	if err != nil {
+		return "", err
+	}
+
+	rule, err := parsed.rule()
+	if err != nil {
+		return "", err
+	}
+
+	repeat := rule + parsed.mods.String()
+	if strings.HasPrefix(rule, RRulePrefix) {
+		repeat = rule + parsed.mods.rruleParts()
+	}
+
+	now := time.Now()
+	_, err = NextDate(now, now.Format(DateFormat), repeat)
+	if err != nil {
+		return "", err
+	}
+
+	return repeat, nil
+}
+
+func splitPhrase(text string) []string {
+	text = strings.ToLower(strings.TrimSpace(text))
+	text = strings.TrimSuffix(text, ".")
+	text = strings.ReplaceAll(text, "ё", "е")
+	text = strings.ReplaceAll(text, "-to-", " to ")
+	text = strings.ReplaceAll(text, ",", " ")
+
+	return strings.Fields(text)
+}
+
+func parsePhrase(words []string) (phrase, error) {
+	parsed := phrase{months: make(map[time.Month]bool)}
+
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		next := ""
+		if i+1 < len(words) {
+			next = words[i+1]
+		}
+
+		if phraseUntil[word] && next != "" {
+			date, ok := parsePhraseDate(next)
+			if !ok {
+				return phrase{}, fmt.Errorf("invalid until date %q", next)
+			}
+
+			parsed.mods.until = date
+			i++
+			continue
+		}
+
+		if ordinal, ok := parsePhraseOrdinal(word); ok {
+			// "second to last" is -2.
+			if next == "to" && i+2 < len(words) && words[i+2] == "last" {
+				ordinal = -ordinal
+				i += 2
+				next = ""
+				if i+1 < len(words) {
+					next = words[i+1]
+				}
+			}
+
+			if weekday, ok := parsePhraseWeekday(next); ok {
+				parsed.weekdays = append(parsed.weekdays, weekdayNum{ordinal: ordinal, weekday: weekday})
+				i++
+				continue
+			}
+
+			if unit, ok := phraseUnits[next]; ok {
+				if unit == "day" {
+					parsed.monthDays = append(parsed.monthDays, ordinal)
+				}
+
+				if ordinal > 1 {
+					parsed.interval, parsed.unit = ordinal, unit
+				}
+
+				parsed.units = append(parsed.units, unit)
+				i++
+				continue
+			}
+
+			parsed.monthDays = append(parsed.monthDays, ordinal)
+			continue
+		}
+
+		if number, err := strconv.Atoi(word); err == nil && number > 0 {
+			if phraseTimes[next] {
+				parsed.mods.count = number
+				i++
+				continue
+			}
+
+			if unit, ok := phraseUnits[next]; ok {
+				parsed.interval, parsed.unit = number, unit
+				i++
+				continue
+			}
+
+			parsed.numbers = append(parsed.numbers, number)
+			continue
+		}
+
+		if weekday, ok := parsePhraseWeekday(word); ok {
+			parsed.weekdays = append(parsed.weekdays, weekdayNum{weekday: weekday})
+			continue
+		}
+
+		if month, ok := parsePhraseMonth(word); ok {
+			parsed.months[month] = true
+			continue
+		}
+
+		if unit, ok := phraseUnits[word]; ok {
+			parsed.units = append(parsed.units, unit)
+			continue
+		}
+
+		if unit, ok := phraseAdverbs[word]; ok {
+			parsed.units = append(parsed.units, unit)
+			continue
+		}
+
+		switch {
+		case phraseEvery[word]:
+			parsed.every = true
+		case word == "other" || word == "через":
+			parsed.other = true
+		case word == "числа" || word == "число":
+			parsed.dayMarker = true
+		case phraseWorkdays[word]:
+			parsed.workdays = true
+		case phraseWeekends[word]:
+			parsed.weekends = true
+		case phraseFillers[word]:
+		default:
+			return phrase{}, fmt.Errorf("unknown word %q", word)
+		}
+	}
+
+	return parsed, nil
+}
+
+func (parsed phrase) hasUnit(unit string) bool {
+	if parsed.unit == unit {
+		return true
+	}
+
+	for _, u := range parsed.units {
+		if u == unit {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (parsed phrase) rule() (string, error) {
+	monthly := parsed.hasUnit("month") || parsed.dayMarker || len(parsed.months) > 0
+
+	if len(parsed.weekdays) > 0 {
+		if len(parsed.numbers) > 0 {
+			return "", fmt.Errorf("unclear number %d", parsed.numbers[0])
+		}
+
+		return parsed.weekdaysRule(monthly)
+	}
+
+	if parsed.workdays {
+		return "w 1,2,3,4,5", nil
+	}
+
+	if parsed.weekends {
+		return "w 6,7", nil
+	}
+
+	days := parsed.monthDays
+	if monthly {
+		days = append(days, parsed.numbers...)
+	} else if len(parsed.numbers) > 0 {
+		return "", fmt.Errorf("unclear number %d", parsed.numbers[0])
+	}
+
+	if len(days) > 0 && (monthly || !parsed.hasUnit("day")) {
+		return parsed.monthDaysRule(days), nil
+	}
+
+	if len(parsed.months) > 0 {
+		return "", fmt.Errorf("a day of the month is missing")
+	}
+
+	unit, interval := parsed.unit, parsed.interval
+	if unit == "" {
+		if len(parsed.units) == 0 {
+			return "", fmt.Errorf("can't tell how often the task repeats")
+		}
+
+		unit, interval = parsed.units[0], 1
+	}
+
+	if parsed.other {
+		interval *= 2
+	}
+
+	switch {
+	case unit == "day":
+		return fmt.Sprintf("d %d", interval), nil
+	case unit == "week":
+		return fmt.Sprintf("d %d", 7*interval), nil
+	case unit == "year" && interval == 1:
+		return "y", nil
+	case interval == 1:
+		return RRulePrefix + "FREQ=MONTHLY", nil
+	default:
+		return fmt.Sprintf("%sFREQ=%sLY;INTERVAL=%d", RRulePrefix, strings.ToUpper(unit), interval), nil
+	}
+}
+
+func (parsed phrase) weekdaysRule(monthly bool) (string, error) {
+	numbered := false
+	for _, day := range parsed.weekdays {
+		if day.ordinal < 0 || day.ordinal != 0 && !parsed.every {
+			monthly = true
+		}
+
+		if day.ordinal != 0 {
+			numbered = true
+		}
+	}
+
+	if numbered && monthly {
+		freq := "MONTHLY"
+		if len(parsed.months) > 0 {
+			freq = "YEARLY"
+		}
+
+		return RRulePrefix + "FREQ=" + freq + ";BYDAY=" + rruleByDay(parsed.weekdays) + parsed.byMonth(), nil
+	}
+
+	interval := 1
+	if parsed.unit == "week" {
+		interval = parsed.interval
+	}
+
+	weekdays := make([]weekdayNum, 0, len(parsed.weekdays))
+	for _, day := range parsed.weekdays {
+		// "every second Friday" is every other Friday.
+		if day.ordinal != 0 {
+			if interval != 1 && interval != day.ordinal {
+				return "", fmt.Errorf("conflicting intervals")
+			}
+
+			interval = day.ordinal
+		}
+
+		weekdays = append(weekdays, weekdayNum{weekday: day.weekday})
+	}
+
+	if parsed.other {
+		interval *= 2
+	}
+
+	if interval == 1 && len(parsed.months) == 0 {
+		var numbers []string
+		for _, day := range weekOrder {
+			for _, weekday := range weekdays {
+				if weekday.weekday == day {
+					numbers = append(numbers, strconv.Itoa(int(day+6)%7+1))
+					break
+				}
+			}
+		}
+
+		return "w " + strings.Join(numbers, ","), nil
+	}
+
+	return fmt.Sprintf("%sFREQ=WEEKLY;INTERVAL=%d;BYDAY=%s%s", RRulePrefix, interval, rruleByDay(weekdays), parsed.byMonth()), nil
+}
+
+func (parsed phrase) monthDaysRule(days []int) string {
+	sort.Slice(days, func(i, j int) bool {
+		if (days[i] < 0) != (days[j] < 0) {
+			return days[i] > 0
+		}
+
+		return days[i] < days[j]
+	})
+
+	var values []string
+	for i, day := range days {
+		if i == 0 || day != days[i-1] {
+			values = append(values, strconv.Itoa(day))
+		}
+	}
+
+	if parsed.unit == "month" && parsed.interval > 1 {
+		return fmt.Sprintf("%sFREQ=MONTHLY;INTERVAL=%d;BYMONTHDAY=%s", RRulePrefix, parsed.interval, strings.Join(values, ","))
+	}
+
+	rule := "m " + strings.Join(values, ",")
+	if len(parsed.months) > 0 {
+		rule += " " + strings.Join(parsed.monthNumbers(), ",")
+	}
+
+	return rule
+}
+
+func (parsed phrase) monthNumbers() []string {
+	var numbers []string
+	for month := time.January; month <= time.December; month++ {
+		if parsed.months[month] {
+			numbers = append(numbers, strconv.Itoa(int(month)))
+		}
+	}
+
+	return numbers
+}
+
+func (parsed phrase) byMonth() string {
+	if len(parsed.months) == 0 {
+		return ""
+	}
+
+	return ";BYMONTH=" + strings.Join(parsed.monthNumbers(), ",")
+}
+
+func (mods modifiers) rruleParts() string {
+	var result string
+
+	if mods.count > 0 {
+		result += fmt.Sprintf(";COUNT=%d", mods.count)
+	}
+
+	if mods.until != "" {
+		result += ";UNTIL=" + mods.until
+	}
+
+	return result
+}
+
+func rruleByDay(weekdays []weekdayNum) string {
+	var values []string
+	for _, day := range weekdays {
+		code := strings.ToUpper(day.weekday.String()[:2])
+		if day.ordinal != 0 {
+			code = strconv.Itoa(day.ordinal) + code
+		}
+
+		values = append(values, code)
+	}
+
+	return strings.Join(values, ",")
+}
+
+func parsePhraseOrdinal(word string) (int, bool) {
+	if ordinal, ok := phraseOrdinals[word]; ok {
+		return ordinal, true
+	}
+
+	number, suffix, ok := strings.Cut(word, "-")
+	if !ok {
+		for _, ending := range []string{"st", "nd", "rd", "th"} {
+			if strings.HasSuffix(word, ending) {
+				number, suffix, ok = strings.TrimSuffix(word, ending), ending, true
+				break
+			}
+		}
+	}
+
+	if !ok || suffix == "" {
+		return 0, false
+	}
+
+	ordinal, err := strconv.Atoi(number)
+	if err != nil || ordinal < 1 {
+		return 0, false
+	}
+
+	return ordinal, true
+}
+
+func parsePhraseWeekday(word string) (time.Weekday, bool) {
+	if weekday, ok := phraseWeekdays[word]; ok {
+		return weekday, true
+	}
+
+	for stem, weekday := range ruWeekdayStems {
+		if strings.HasPrefix(word, stem) {
+			return weekday, true
+		}
+	}
+
+	return 0, false
+}
+
+func parsePhraseMonth(word string) (time.Month, bool) {
+	if month, ok := phraseMonths[word]; ok {
+		return month, true
+	}
+
+	for stem, month := range ruMonthStems {
+		if strings.HasPrefix(word, stem) {
+			return month, true
+		}
+	}
+
+	return 0, false
+}
+
+func parsePhraseDate(word string) (string, bool) {
+	for _, layout := range phraseDateLayouts {
+		date, err := time.Parse(layout, word)
+		if err == nil {
+			return date.Format(DateFormat), true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,78 @@
+package utils
+
+import "testing"
+
+func TestParseRepeatPhrase(t *testing.T) {
+	tbl := []struct {
+		text string
+		want string
+	}{
+		{"every day", "d 1"},
+		{"Daily", "d 1"},
+		{"every other day", "d 2"},
+		{"every 3 days", "d 3"},
+		{"every second day", "d 2"},
+		{"weekly", "d 7"},
+		{"every 2 weeks", "d 14"},
+		{"every year", "y"},
+		{"every month", "RRULE:FREQ=MONTHLY"},
+		{"every 3 months", "RRULE:FREQ=MONTHLY;INTERVAL=3"},
+		{"every monday", "w 1"},
+		{"every Friday, Monday and Wednesday", "w 1,3,5"},
+		{"every other Friday", "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=FR"},
+		{"every 2 weeks on Tuesday and Thursday", "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH"},
+		{"every weekday", "w 1,2,3,4,5"},
+		{"on weekends", "w 6,7"},
+		{"last day of the month", "m -1"},
+		{"second-to-last day of every month", "m -2"},
+		{"the 1st and 15th", "m 1,15"},
+		{"every month on the 15th", "m 15"},
+		{"every 2 months on the 10th", "RRULE:FREQ=MONTHLY;INTERVAL=2;BYMONTHDAY=10"},
+		{"March 8", "m 8 3"},
+		{"first Monday of the month", "RRULE:FREQ=MONTHLY;BYDAY=1MO"},
+		{"last Friday of every month", "RRULE:FREQ=MONTHLY;BYDAY=-1FR"},
+		{"second Sunday of May", "RRULE:FREQ=YEARLY;BYDAY=2SU;BYMONTH=5"},
+		{"every day 5 times", "d 1 count 5"},
+		{"every monday until 2099-12-31", "w 1 until 20991231"},
+		{"every other friday 3 times", "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=FR;COUNT=3"},
+		{"ежедневно", "d 1"},
+		{"каждый день", "d 1"},
+		{"через день", "d 2"},
+		{"каждые 3 дня", "d 3"},
+		{"каждую неделю", "d 7"},
+		{"ежегодно", "y"},
+		{"каждый понедельник", "w 1"},
+		{"по вторникам и четвергам", "w 2,4"},
+		{"по будням", "w 1,2,3,4,5"},
+		{"по выходным", "w 6,7"},
+		{"каждую вторую пятницу", "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=FR"},
+		{"через пятницу", "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=FR"},
+		{"последний день месяца", "m -1"},
+		{"в предпоследний день месяца", "m -2"},
+		{"1 и 15 числа каждого месяца", "m 1,15"},
+		{"15-го числа", "m 15"},
+		{"8 марта", "m 8 3"},
+		{"в последнюю пятницу месяца", "RRULE:FREQ=MONTHLY;BYDAY=-1FR"},
+		{"первый понедельник месяца", "RRULE:FREQ=MONTHLY;BYDAY=1MO"},
+		{"каждое воскресенье до 31.12.2099", "w 7 until 20991231"},
+		{"каждый день 10 раз", "d 1 count 10"},
+	}
+	for _, v := range tbl {
+		got, err := ParseRepeatPhrase(v.text)
+		if err != nil {
+			t.Errorf("ParseRepeatPhrase(%q) returned %v", v.text, err)
+			continue
+		}
+
+		if got != v.want {
+			t.Errorf("ParseRepeatPhrase(%q) = %q, want %q", v.text, got, v.want)
+		}
+	}
+
+	for _, text := range []string{"", "sometimes", "every 500 days", "every monday at 9", "February 30", "every day until yesterday"} {
+		got, err := ParseRepeatPhrase(text)
+		if err == nil {
+			t.Errorf("ParseRepeatPhrase(%q) = %q, want an error", text, got)
+		}
+	}
+}
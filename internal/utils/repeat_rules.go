@@ -0,0 +1,285 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RuleType is a kind of repeat rule. Rules are looked up by their first word,
+// e.g. "d" for "d 7", and only then offered to Match.
+type RuleType struct {
+	Name string
+
+	// Match is optional and lets a type claim rules that don't start with
+	// the name of any type, such as cron expressions.
+	Match func(rule string) bool
+
+	// Parse validates a whole rule, name included, and returns it ready to
+	// compute dates with.
+	Parse func(rule string) (Rule, error)
+
+	// Timed rules fire at a time of day and move the time of the task too.
+	Timed bool
+}
+
+// Rule is a parsed repeat rule.
+type Rule interface {
+	// Next returns the first occurrence after both start and now. Start is
+	// the task date, with the task time for timed rules.
+	Next(start, now time.Time) (time.Time, error)
+
+	// Describe returns the rule in words, e.g. "every 7 days".
+	Describe() string
+}
+
+// LocalizedRule is implemented by rules that can be described in other
+// languages than English. DescribeRepeat falls back to Describe for the
+// rest.
+type LocalizedRule interface {
+	Rule
+
+	// DescribeIn returns the rule in words in one of the Lang languages.
+	DescribeIn(lang string) string
+}
+
+// seriesRule is implemented by rules that carry their own end condition,
+// such as an RRULE with COUNT. They return the rule to store with the next
+// date and can't be combined with modifiers.
+type seriesRule interface {
+	Rule
+	advance(start, now time.Time) (time.Time, string, error)
+}
+
+var ruleTypes []RuleType
+
+var weekOrder = []time.Weekday{
+	time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday,
+}
+
+// RegisterRule makes a rule type available to NextDate and the task
+// validation. It panics when the name is taken, like sql.Register.
+func RegisterRule(ruleType RuleType) {
+	if ruleType.Name == "" || ruleType.Parse == nil {
+		panic("utils: RegisterRule needs a name and a parser")
+	}
+
+	for _, registered := range ruleTypes {
+		if registered.Name == ruleType.Name {
+			panic("utils: RegisterRule called twice for " + ruleType.Name)
+		}
+	}
+
+	ruleTypes = append(ruleTypes, ruleType)
+}
+
+func parseRule(rule string) (RuleType, Rule, error) {
+	if rule == "" {
+		return RuleType{}, nil, fmt.Errorf("empty repeat rule")
+	}
+
+	name, _, _ := strings.Cut(rule, " ")
+	for _, ruleType := range ruleTypes {
+		if ruleType.Name == name {
+			return parseRuleAs(ruleType, rule)
+		}
+	}
+
+	for _, ruleType := range ruleTypes {
+		if ruleType.Match != nil && ruleType.Match(rule) {
+			return parseRuleAs(ruleType, rule)
+		}
+	}
+
+	return RuleType{}, nil, fmt.Errorf("unsupported format")
+}
+
+// RuleTypeName returns the name of the type of a repeat rule, e.g. "w" or
+// "cron", or "" when the rule is empty or invalid.
+func RuleTypeName(repeat string) string {
+	ruleStr, _, err := parseModifiers(repeat)
+	if err != nil {
+		return ""
+	}
+
+	ruleType, _, err := parseRule(ruleStr)
+	if err != nil {
+		return ""
+	}
+
+	return ruleType.Name
+}
+
+func parseRuleAs(ruleType RuleType, rule string) (RuleType, Rule, error) {
+	parsed, err := ruleType.Parse(rule)
+	if err != nil {
+		return RuleType{}, nil, err
+	}
+
+	return ruleType, parsed, nil
+}
+
+func init() {
+	RegisterRule(RuleType{Name: "d", Parse: parseDaysRule})
+	RegisterRule(RuleType{Name: "y", Parse: parseYearRule})
+	RegisterRule(RuleType{Name: "w", Parse: parseWeekdaysRule})
+	RegisterRule(RuleType{Name: "m", Parse: parseMonthDaysRule})
+	RegisterRule(RuleType{Name: "RRULE", Match: isRRule, Parse: parseRRuleRule})
+	RegisterRule(RuleType{Name: "cron", Match: isCron, Parse: parseCronRule, Timed: true})
+}
+
+type daysRule struct {
+	days int
+}
+
+func parseDaysRule(rule string) (Rule, error) {
+	parts := strings.Split(rule, " ")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid 'd' rule format")
+	}
+
+	days, err := strconv.Atoi(parts[1])
+	if err != nil || days < 1 || days > 400 {
+		return nil, fmt.Errorf("invalid number of days")
+	}
+
+	return daysRule{days: days}, nil
+}
+
+func (rule daysRule) Next(start, now time.Time) (time.Time, error) {
+	return nextDateByDays(start, now, rule.days), nil
+}
+
+func (rule daysRule) Describe() string {
+	return rule.DescribeIn(LangEnglish)
+}
+
+func (rule daysRule) DescribeIn(lang string) string {
+	if lang == LangRussian {
+		return ruEvery(rule.days, "каждый день", "день", "дня", "дней")
+	}
+
+	if rule.days == 1 {
+		return "every day"
+	}
+
+	return fmt.Sprintf("every %d days", rule.days)
+}
+
+type yearRule struct{}
+
+func parseYearRule(rule string) (Rule, error) {
+	if rule != "y" {
+		return nil, fmt.Errorf("invalid 'y' rule format")
+	}
+
+	return yearRule{}, nil
+}
+
+func (rule yearRule) Next(start, now time.Time) (time.Time, error) {
+	return nextDateByYear(start, now), nil
+}
+
+func (rule yearRule) Describe() string {
+	return rule.DescribeIn(LangEnglish)
+}
+
+func (rule yearRule) DescribeIn(lang string) string {
+	if lang == LangRussian {
+		return "ежегодно"
+	}
+
+	return "every year"
+}
+
+type weekdaysRule struct {
+	weekdays map[time.Weekday]bool
+}
+
+func parseWeekdaysRule(rule string) (Rule, error) {
+	parts := strings.Split(rule, " ")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid 'w' rule format")
+	}
+
+	weekdays, err := parseWeekdays(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return weekdaysRule{weekdays: weekdays}, nil
+}
+
+func (rule weekdaysRule) Next(start, now time.Time) (time.Time, error) {
+	return nextDateByWeekdays(start, now, rule.weekdays), nil
+}
+
+func (rule weekdaysRule) Describe() string {
+	return rule.DescribeIn(LangEnglish)
+}
+
+func (rule weekdaysRule) DescribeIn(lang string) string {
+	var weekdays []time.Weekday
+	for _, day := range weekOrder {
+		if rule.weekdays[day] {
+			weekdays = append(weekdays, day)
+		}
+	}
+
+	if lang == LangRussian {
+		return describeWeekdays(weekdays, lang)
+	}
+
+	return "every " + describeWeekdays(weekdays, lang)
+}
+
+type monthDaysRule struct {
+	days   []int
+	months map[time.Month]bool
+}
+
+func parseMonthDaysRule(rule string) (Rule, error) {
+	parts := strings.Split(rule, " ")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil, fmt.Errorf("invalid 'm' rule format")
+	}
+
+	days, err := parseMonthDays(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	months := make(map[time.Month]bool)
+	if len(parts) == 3 {
+		months, err = parseMonths(parts[2])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return monthDaysRule{days: days, months: months}, nil
+}
+
+func (rule monthDaysRule) Next(start, now time.Time) (time.Time, error) {
+	return nextDateByMonthDays(start, now, rule.days, rule.months)
+}
+
+func (rule monthDaysRule) Describe() string {
+	return rule.DescribeIn(LangEnglish)
+}
+
+func (rule monthDaysRule) DescribeIn(lang string) string {
+	days := describeMonthDays(rule.days, lang)
+
+	switch {
+	case len(rule.months) > 0 && lang == LangRussian:
+		return days + " " + describeMonths(rule.months, lang)
+	case len(rule.months) > 0:
+		return "on " + days + " " + describeMonths(rule.months, lang)
+	case lang == LangRussian:
+		return days + " каждого месяца"
+	default:
+		return "on " + days + " of every month"
+	}
+}
@@ -0,0 +1,607 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const RRulePrefix = "RRULE:"
+
+// ErrNoNextDate is returned when a repeat rule has run out of occurrences.
+var ErrNoNextDate = fmt.Errorf("the repeat rule has no further occurrences")
+
+var rruleWeekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+type weekdayNum struct {
+	ordinal int
+	weekday time.Weekday
+}
+
+type rrule struct {
+	freq       string
+	interval   int
+	count      int
+	until      string
+	byDay      []weekdayNum
+	byMonthDay []int
+	byMonth    map[time.Month]bool
+	bySetPos   []int
+	weekStart  time.Weekday
+}
+
+type rruleRule struct {
+	value string
+	rule  rrule
+}
+
+func isRRule(rule string) bool {
+	return strings.HasPrefix(rule, RRulePrefix)
+}
+
+func parseRRuleRule(rule string) (Rule, error) {
+	value := strings.TrimPrefix(rule, RRulePrefix)
+
+	parsed, err := parseRRule(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return rruleRule{value: value, rule: parsed}, nil
+}
+
+func (rule rruleRule) Next(start, now time.Time) (time.Time, error) {
+	next, _, err := nextDateByRRule(start, now, rule.rule)
+	return next, err
+}
+
+func (rule rruleRule) advance(start, now time.Time) (time.Time, string, error) {
+	next, passed, err := nextDateByRRule(start, now, rule.rule)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+
+	return next, RRulePrefix + reduceRRuleCount(rule.value, passed), nil
+}
+
+func (rule rruleRule) Describe() string {
+	return rule.DescribeIn(LangEnglish)
+}
+
+func (rule rruleRule) DescribeIn(lang string) string {
+	return rule.rule.describe(lang)
+}
+
+func parseRRule(value string) (rrule, error) {
+	rule := rrule{
+		interval:  1,
+		byMonth:   make(map[time.Month]bool),
+		weekStart: time.Monday,
+	}
+
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(value, ";") {
+		key, val, ok := strings.Cut(part, "=")
+		if !ok || val == "" {
+			return rrule{}, fmt.Errorf("invalid RRULE part %q", part)
+		}
+
+		if seen[key] {
+			return rrule{}, fmt.Errorf("duplicate RRULE part %q", key)
+		}
+		seen[key] = true
+
+		var err error
+		switch key {
+		case "FREQ":
+			switch val {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				rule.freq = val
+			default:
+				return rrule{}, fmt.Errorf("unsupported RRULE frequency %q", val)
+			}
+		case "INTERVAL":
+			rule.interval, err = strconv.Atoi(val)
+			if err != nil || rule.interval < 1 {
+				return rrule{}, fmt.Errorf("invalid RRULE interval")
+			}
+		case "COUNT":
+			rule.count, err = strconv.Atoi(val)
+			if err != nil || rule.count < 1 {
+				return rrule{}, fmt.Errorf("invalid RRULE count")
+			}
+		case "UNTIL":
+			rule.until, err = parseRRuleUntil(val)
+			if err != nil {
+				return rrule{}, err
+			}
+		case "BYDAY":
+			rule.byDay, err = parseRRuleByDay(val)
+			if err != nil {
+				return rrule{}, err
+			}
+		case "BYMONTHDAY":
+			rule.byMonthDay, err = parseRRuleNumbers(val, 31)
+			if err != nil {
+				return rrule{}, fmt.Errorf("invalid RRULE day of the month")
+			}
+		case "BYMONTH":
+			months, err := parseRRuleNumbers(val, 12)
+			if err != nil {
+				return rrule{}, fmt.Errorf("invalid RRULE month")
+			}
+
+			for _, month := range months {
+				if month < 0 {
+					return rrule{}, fmt.Errorf("invalid RRULE month")
+				}
+
+				rule.byMonth[time.Month(month)] = true
+			}
+		case "BYSETPOS":
+			rule.bySetPos, err = parseRRuleNumbers(val, 366)
+			if err != nil {
+				return rrule{}, fmt.Errorf("invalid RRULE set position")
+			}
+		case "WKST":
+			weekday, ok := rruleWeekdays[val]
+			if !ok {
+				return rrule{}, fmt.Errorf("invalid RRULE week start")
+			}
+
+			rule.weekStart = weekday
+		default:
+			return rrule{}, fmt.Errorf("unsupported RRULE part %q", key)
+		}
+	}
+
+	if rule.freq == "" {
+		return rrule{}, fmt.Errorf("RRULE must contain FREQ")
+	}
+
+	if rule.count > 0 && rule.until != "" {
+		return rrule{}, fmt.Errorf("RRULE must not contain both COUNT and UNTIL")
+	}
+
+	if rule.freq == "WEEKLY" && len(rule.byMonthDay) > 0 {
+		return rrule{}, fmt.Errorf("BYMONTHDAY is not allowed with FREQ=WEEKLY")
+	}
+
+	for _, day := range rule.byDay {
+		if day.ordinal != 0 && rule.freq != "MONTHLY" && rule.freq != "YEARLY" {
+			return rrule{}, fmt.Errorf("numbered BYDAY is only allowed with FREQ=MONTHLY or FREQ=YEARLY")
+		}
+	}
+
+	if len(rule.bySetPos) > 0 && len(rule.byDay) == 0 && len(rule.byMonthDay) == 0 && len(rule.byMonth) == 0 {
+		return rrule{}, fmt.Errorf("BYSETPOS requires another BY rule part")
+	}
+
+	return rule, nil
+}
+
+func parseRRuleUntil(value string) (string, error) {
+	if len(value) < len(DateFormat) {
+		return "", fmt.Errorf("invalid RRULE until date")
+	}
+
+	date, err := time.Parse(DateFormat, value[:len(DateFormat)])
+	if err != nil {
+		return "", fmt.Errorf("invalid RRULE until date")
+	}
+
+	rest := value[len(DateFormat):]
+	if rest != "" {
+		_, err = time.Parse("T150405Z", rest)
+		if err != nil {
+			_, err = time.Parse("T150405", rest)
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("invalid RRULE until date")
+		}
+	}
+
+	return date.Format(DateFormat), nil
+}
+
+func parseRRuleByDay(value string) ([]weekdayNum, error) {
+	var days []weekdayNum
+
+	for _, item := range strings.Split(value, ",") {
+		if len(item) < 2 {
+			return nil, fmt.Errorf("invalid RRULE weekday %q", item)
+		}
+
+		weekday, ok := rruleWeekdays[item[len(item)-2:]]
+		if !ok {
+			return nil, fmt.Errorf("invalid RRULE weekday %q", item)
+		}
+
+		var ordinal int
+		if prefix := item[:len(item)-2]; prefix != "" {
+			var err error
+			ordinal, err = strconv.Atoi(prefix)
+			if err != nil || ordinal == 0 || ordinal < -53 || ordinal > 53 {
+				return nil, fmt.Errorf("invalid RRULE weekday %q", item)
+			}
+		}
+
+		days = append(days, weekdayNum{ordinal: ordinal, weekday: weekday})
+	}
+
+	return days, nil
+}
+
+func parseRRuleNumbers(value string, limit int) ([]int, error) {
+	var numbers []int
+
+	for _, item := range strings.Split(value, ",") {
+		number, err := strconv.Atoi(item)
+		if err != nil || number == 0 || number < -limit || number > limit {
+			return nil, fmt.Errorf("invalid number %q", item)
+		}
+
+		numbers = append(numbers, number)
+	}
+
+	return numbers, nil
+}
+
+// nextDateByRRule also returns how many occurrences since the task date come
+// before the next one, so that COUNT can be reduced when the task moves.
+func nextDateByRRule(taskDate, now time.Time, rule rrule) (time.Time, int, error) {
+	after := taskDate
+	if today := dateOf(now); today.After(after) {
+		after = today
+	}
+
+	// Without COUNT nothing before "after" matters, so start at its period.
+	index := 0
+	if rule.count == 0 {
+		index = rule.periodsBetween(taskDate, after)
+	}
+
+	var found int
+	lastMatch := rule.periodStart(taskDate, index)
+	for ; ; index++ {
+		period := rule.periodStart(taskDate, index)
+		if rule.until != "" && period.Format(DateFormat) > rule.until {
+			return time.Time{}, 0, ErrNoNextDate
+		}
+
+		if period.After(lastMatch.AddDate(maxMonthSearch/12*rule.interval, 0, 0)) {
+			return time.Time{}, 0, fmt.Errorf("the RRULE never matches a date")
+		}
+
+		for _, date := range rule.expand(taskDate, period) {
+			if date.Before(taskDate) {
+				continue
+			}
+
+			lastMatch = date
+			found++
+			if rule.count > 0 && found > rule.count {
+				return time.Time{}, 0, ErrNoNextDate
+			}
+
+			if rule.until != "" && date.Format(DateFormat) > rule.until {
+				return time.Time{}, 0, ErrNoNextDate
+			}
+
+			if date.After(after) {
+				return date, found - 1, nil
+			}
+		}
+	}
+}
+
+var rruleUnits = map[string]string{
+	"DAILY":   "day",
+	"WEEKLY":  "week",
+	"MONTHLY": "month",
+	"YEARLY":  "year",
+}
+
+// ruRRuleUnits holds the Russian "every <unit>" and the forms of the unit
+// that ruEvery needs.
+var ruRRuleUnits = map[string][4]string{
+	"DAILY":   {"каждый день", "день", "дня", "дней"},
+	"WEEKLY":  {"каждую неделю", "неделю", "недели", "недель"},
+	"MONTHLY": {"каждый месяц", "месяц", "месяца", "месяцев"},
+	"YEARLY":  {"каждый год", "год", "года", "лет"},
+}
+
+func (rule rrule) describe(lang string) string {
+	if lang == LangRussian {
+		return rule.describeRussian()
+	}
+
+	result := "every " + rruleUnits[rule.freq]
+	if rule.interval > 1 {
+		result = fmt.Sprintf("every %d %ss", rule.interval, rruleUnits[rule.freq])
+	}
+
+	if len(rule.byDay) > 0 {
+		var days []string
+		for _, day := range rule.byDay {
+			days = append(days, describeWeekdayNum(day, lang))
+		}
+
+		result += " on " + strings.Join(days, ", ")
+	}
+
+	if len(rule.byMonthDay) > 0 {
+		var days []string
+		for _, day := range rule.byMonthDay {
+			days = append(days, describeOrdinal(day)+" day")
+		}
+
+		result += " on the " + strings.Join(days, ", ")
+	}
+
+	if len(rule.byMonth) > 0 {
+		result += " " + describeMonths(rule.byMonth, lang)
+	}
+
+	if len(rule.bySetPos) > 0 {
+		var positions []string
+		for _, position := range rule.bySetPos {
+			positions = append(positions, describeOrdinal(position))
+		}
+
+		result += ", only the " + strings.Join(positions, ", ") + " match"
+	}
+
+	return result + modifiers{count: rule.count, until: rule.until}.describe(lang)
+}
+
+func (rule rrule) describeRussian() string {
+	unit := ruRRuleUnits[rule.freq]
+	result := ruEvery(rule.interval, unit[0], unit[1], unit[2], unit[3])
+
+	if len(rule.byDay) > 0 {
+		var days []string
+		var plain []time.Weekday
+		for _, day := range rule.byDay {
+			if day.ordinal == 0 {
+				plain = append(plain, day.weekday)
+			} else {
+				days = append(days, "в "+describeWeekdayNum(day, LangRussian))
+			}
+		}
+
+		if len(plain) > 0 {
+			days = append([]string{describeWeekdays(plain, LangRussian)}, days...)
+		}
+
+		result += " " + strings.Join(days, ", ")
+	}
+
+	if len(rule.byMonthDay) > 0 {
+		var days []string
+		for _, day := range rule.byMonthDay {
+			switch {
+			case day == -1:
+				days = append(days, "в последний день")
+			case day < 0:
+				days = append(days, fmt.Sprintf("в %d-й с конца день", -day))
+			default:
+				days = append(days, fmt.Sprintf("%d-го числа", day))
+			}
+		}
+
+		result += " " + strings.Join(days, ", ")
+	}
+
+	if len(rule.byMonth) > 0 {
+		result += " " + describeMonths(rule.byMonth, LangRussian)
+	}
+
+	if len(rule.bySetPos) > 0 {
+		var positions []string
+		for _, position := range rule.bySetPos {
+			switch {
+			case position == -1:
+				positions = append(positions, "последнее")
+			case position < 0:
+				positions = append(positions, fmt.Sprintf("%d-е с конца", -position))
+			default:
+				positions = append(positions, fmt.Sprintf("%d-е", position))
+			}
+		}
+
+		result += ", только " + strings.Join(positions, ", ") + " совпадение"
+	}
+
+	return result + modifiers{count: rule.count, until: rule.until}.describe(LangRussian)
+}
+
+func reduceRRuleCount(value string, passed int) string {
+	parts := strings.Split(value, ";")
+
+	for i, part := range parts {
+		count, ok := strings.CutPrefix(part, "COUNT=")
+		if !ok {
+			continue
+		}
+
+		number, err := strconv.Atoi(count)
+		if err == nil {
+			parts[i] = fmt.Sprintf("COUNT=%d", number-passed)
+		}
+	}
+
+	return strings.Join(parts, ";")
+}
+
+func dateOf(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func (rule rrule) weekOf(date time.Time) time.Time {
+	offset := (int(date.Weekday()) - int(rule.weekStart) + 7) % 7
+	return date.AddDate(0, 0, -offset)
+}
+
+func (rule rrule) periodStart(start time.Time, index int) time.Time {
+	step := index * rule.interval
+
+	switch rule.freq {
+	case "DAILY":
+		return start.AddDate(0, 0, step)
+	case "WEEKLY":
+		return rule.weekOf(start).AddDate(0, 0, 7*step)
+	case "MONTHLY":
+		return time.Date(start.Year(), start.Month()+time.Month(step), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(start.Year()+step, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+func (rule rrule) periodsBetween(start, date time.Time) int {
+	var units int
+
+	switch rule.freq {
+	case "DAILY":
+		units = int(daysBetween(start, date))
+	case "WEEKLY":
+		units = int(daysBetween(rule.weekOf(start), rule.weekOf(date)) / 7)
+	case "MONTHLY":
+		units = (date.Year()-start.Year())*12 + int(date.Month()-start.Month())
+	default:
+		units = date.Year() - start.Year()
+	}
+
+	return units / rule.interval
+}
+
+func (rule rrule) periodEnd(period time.Time) time.Time {
+	switch rule.freq {
+	case "DAILY":
+		return period.AddDate(0, 0, 1)
+	case "WEEKLY":
+		return period.AddDate(0, 0, 7)
+	case "MONTHLY":
+		return period.AddDate(0, 1, 0)
+	default:
+		return period.AddDate(1, 0, 0)
+	}
+}
+
+func (rule rrule) expand(start, period time.Time) []time.Time {
+	var dates []time.Time
+
+	end := rule.periodEnd(period)
+	for date := period; date.Before(end); date = date.AddDate(0, 0, 1) {
+		if rule.matches(start, date) {
+			dates = append(dates, date)
+		}
+	}
+
+	if len(rule.bySetPos) == 0 {
+		return dates
+	}
+
+	var selected []time.Time
+	for _, position := range rule.bySetPos {
+		if position > 0 && position <= len(dates) {
+			selected = append(selected, dates[position-1])
+		} else if position < 0 && -position <= len(dates) {
+			selected = append(selected, dates[len(dates)+position])
+		}
+	}
+
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Before(selected[j]) })
+
+	return selected
+}
+
+func (rule rrule) matches(start, date time.Time) bool {
+	if len(rule.byMonth) > 0 && !rule.byMonth[date.Month()] {
+		return false
+	}
+
+	if len(rule.byMonthDay) > 0 && !matchesMonthDay(date, rule.byMonthDay) {
+		return false
+	}
+
+	if len(rule.byDay) > 0 && !rule.matchesByDay(date) {
+		return false
+	}
+
+	switch rule.freq {
+	case "WEEKLY":
+		if len(rule.byDay) == 0 {
+			return date.Weekday() == start.Weekday()
+		}
+	case "MONTHLY":
+		if len(rule.byDay) == 0 && len(rule.byMonthDay) == 0 {
+			return date.Day() == start.Day()
+		}
+	case "YEARLY":
+		if len(rule.byDay) == 0 && len(rule.byMonthDay) == 0 {
+			if len(rule.byMonth) == 0 && date.Month() != start.Month() {
+				return false
+			}
+
+			return date.Day() == start.Day()
+		}
+	}
+
+	return true
+}
+
+func matchesMonthDay(date time.Time, days []int) bool {
+	last := date.AddDate(0, 1, -date.Day()).Day()
+
+	for _, day := range days {
+		if day == date.Day() || day < 0 && last+day+1 == date.Day() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (rule rrule) matchesByDay(date time.Time) bool {
+	for _, day := range rule.byDay {
+		if day.weekday != date.Weekday() {
+			continue
+		}
+
+		if day.ordinal == 0 {
+			return true
+		}
+
+		var position, total int
+		if rule.freq == "MONTHLY" || len(rule.byMonth) > 0 {
+			position = date.Day()
+			total = date.AddDate(0, 1, -date.Day()).Day()
+		} else {
+			position = date.YearDay()
+			total = time.Date(date.Year(), time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+		}
+
+		if day.ordinal > 0 && (position-1)/7+1 == day.ordinal {
+			return true
+		}
+
+		if day.ordinal < 0 && (total-position)/7+1 == -day.ordinal {
+			return true
+		}
+	}
+
+	return false
+}
@@ -9,7 +9,14 @@ import (
 	"time"
 )
 
-const DateFormat = "20060102"
+const (
+	DateFormat = "20060102"
+	TimeFormat = "15:04"
+)
+
+// maxMonthSearch covers the longest gap between two February 29ths,
+// so rules like "m 30 2" fail instead of looping forever.
+const maxMonthSearch = 12 * 9
 
 func WriteJSONError(w http.ResponseWriter, message string, statusCode int) {
 	w.Header().Set("Content-Type", "application/json")
@@ -31,58 +38,507 @@ func GetAndCheckId(r *http.Request) (int, error) {
 }
 
 func NextDate(now time.Time, date string, repeat string) (string, error) {
+	next, _, err := NextDateTime(now, date, "", repeat, "")
+	return next, err
+}
+
+// NextDateTime is NextDate for tasks with a time of day and a comma-separated
+// list of skipped dates. Cron rules can fire several times a day and return
+// the time of the next run, every other rule keeps the time of the task.
+func NextDateTime(now time.Time, date, clock, repeat, skip string) (string, string, error) {
+	next, nextTime, _, err := Advance(now, date, clock, repeat, skip)
+	return next, nextTime, err
+}
+
+// Advance is NextDateTime that also returns the repeat rule to store with the
+// next date: an occurrence count is reduced by the occurrences passed on the
+// way, skipped ones included. When the rule has run out of occurrences it
+// returns ErrNoNextDate.
+func Advance(now time.Time, date, clock, repeat, skip string) (string, string, string, error) {
+	next, nextTime, nextRepeat, err := advance(now, date, clock, repeat)
+
+	for err == nil && next != "" && IsSkipped(skip, next) {
+		var current time.Time
+		current, err = occurrenceTime(next, nextTime)
+		if err != nil {
+			return "", "", "", err
+		}
+
+		next, nextTime, nextRepeat, err = advance(current, next, nextTime, nextRepeat)
+	}
+
+	return next, nextTime, nextRepeat, err
+}
+
+func IsSkipped(skip, date string) bool {
+	for _, skipped := range strings.Split(skip, ",") {
+		if skipped == date {
+			return true
+		}
+	}
+
+	return false
+}
+
+func advance(now time.Time, date, clock, repeat string) (string, string, string, error) {
+	// Dates and times of tasks are plain local values, so "now" is taken
+	// by its wall clock in whatever timezone it comes in.
+	now = wallClock(now)
+
 	taskDate, err := time.Parse(DateFormat, date)
 	if err != nil {
-		return "", fmt.Errorf("invalid date format: %v", err)
+		return "", "", "", fmt.Errorf("invalid date format: %v", err)
 	}
 
 	if repeat == "" {
-		return "", nil
+		return "", "", "", nil
 	}
 
-	parts := strings.Split(repeat, " ")
-	if len(parts) == 0 {
-		return "", fmt.Errorf("empty repeat rule")
+	ruleStr, mods, ruleType, rule, err := parseRepeat(repeat)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	if series, ok := rule.(seriesRule); ok {
+		next, nextRepeat, err := series.advance(taskDate, now)
+		if err != nil {
+			return "", "", "", err
+		}
+
+		return next.Format(DateFormat), clock, nextRepeat, nil
+	}
+
+	base, err := mods.base(date)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	if mods.count == 0 {
+		next, unshifted, nextTime, err := nextWorkdayTime(now, base, date, clock, ruleType, rule, mods.shift)
+		if err != nil {
+			return "", "", "", err
+		}
+
+		if mods.until != "" && next > mods.until {
+			return "", "", "", ErrNoNextDate
+		}
+
+		if mods.shift == 0 {
+			return next, nextTime, repeat, nil
+		}
+
+		return next, nextTime, ruleStr + mods.from(next, unshifted).String(), nil
+	}
+
+	next, nextTime := date, clock
+	for passed := 1; passed < mods.count; passed++ {
+		current, err := occurrenceTime(base, nextTime)
+		if err != nil {
+			return "", "", "", err
+		}
+
+		next, base, nextTime, err = nextWorkdayTime(current, base, next, nextTime, ruleType, rule, mods.shift)
+		if err != nil {
+			return "", "", "", err
+		}
+
+		if isAfter(now, next, nextTime, ruleType.Timed) {
+			mods.count -= passed
+			return next, nextTime, ruleStr + mods.from(next, base).String(), nil
+		}
+	}
+
+	return "", "", "", ErrNoNextDate
+}
+
+// ValidateRepeat checks a repeat rule without computing any dates, so a
+// series that has run out of occurrences still passes.
+func ValidateRepeat(repeat string) error {
+	_, _, _, _, err := parseRepeat(repeat)
+	return err
+}
+
+// parseRepeat splits a repeat rule into the rule and its modifiers and
+// parses the rule.
+func parseRepeat(repeat string) (string, modifiers, RuleType, Rule, error) {
+	ruleStr, mods, err := parseModifiers(repeat)
+	if err != nil {
+		return "", modifiers{}, RuleType{}, nil, err
+	}
+
+	ruleType, rule, err := parseRule(ruleStr)
+	if err != nil {
+		return "", modifiers{}, RuleType{}, nil, err
+	}
+
+	if _, ok := rule.(seriesRule); ok && mods != (modifiers{}) {
+		return "", modifiers{}, RuleType{}, nil, fmt.Errorf("a %s rule can't be combined with count, until or workday", ruleType.Name)
+	}
+
+	return ruleStr, mods, ruleType, rule, nil
+}
+
+// modifiers are the optional words after a repeat rule: "count N" or
+// "until YYYYMMDD" end the series, and "workday" or "workday-" move an
+// occurrence that falls on a day off to the next or previous workday. A
+// moved occurrence is stored with "from YYYYMMDD", the date it was moved
+// from, and the next occurrences are computed from that date so that the
+// series doesn't drift.
+type modifiers struct {
+	count     int
+	until     string
+	shift     int
+	unshifted string
+}
+
+// base returns the date to compute the next occurrence from: the date a
+// task on date was moved from, as long as it still moves to date. Once the
+// date is changed, as when it is edited or the task recurs from its
+// completion, the date itself is used.
+func (mods modifiers) base(date string) (string, error) {
+	if mods.unshifted == "" {
+		return date, nil
+	}
+
+	shifted, err := shiftToWorkday(mods.unshifted, mods.shift)
+	if err != nil {
+		return "", err
+	}
+
+	if shifted != date {
+		return date, nil
+	}
+
+	return mods.unshifted, nil
+}
+
+// from returns the modifiers to store with the occurrence next, which was
+// moved from unshifted.
+func (mods modifiers) from(next, unshifted string) modifiers {
+	mods.unshifted = ""
+	if unshifted != next {
+		mods.unshifted = unshifted
 	}
 
-	rule := parts[0]
+	return mods
+}
 
-	if rule == "d" {
-		if len(parts) != 2 {
-			return "", fmt.Errorf("invalid 'd' rule format")
+func parseModifiers(repeat string) (string, modifiers, error) {
+	var mods modifiers
+	parts := strings.Split(repeat, " ")
+
+	for len(parts) > 1 {
+		last := parts[len(parts)-1]
+
+		if last == "workday" || last == "workday+" || last == "workday-" {
+			if mods.shift != 0 {
+				return "", modifiers{}, fmt.Errorf("duplicate workday modifier")
+			}
+
+			mods.shift = 1
+			if last == "workday-" {
+				mods.shift = -1
+			}
+
+			parts = parts[:len(parts)-1]
+			continue
+		}
+
+		if len(parts) < 3 {
+			break
+		}
+
+		keyword := parts[len(parts)-2]
+		if keyword == "from" {
+			if mods.unshifted != "" {
+				return "", modifiers{}, fmt.Errorf("duplicate from modifier")
+			}
+
+			_, err := time.Parse(DateFormat, last)
+			if err != nil {
+				return "", modifiers{}, fmt.Errorf("invalid from date")
+			}
+
+			mods.unshifted = last
+			parts = parts[:len(parts)-2]
+			continue
 		}
 
-		days, err := strconv.Atoi(parts[1])
-		if err != nil || days < 1 || days > 400 {
-			return "", fmt.Errorf("invalid number of days")
+		if keyword != "count" && keyword != "until" {
+			break
 		}
 
-		return nextDateByDays(taskDate, now, days), nil
-	} else if rule == "y" {
-		if len(parts) != 1 {
-			return "", fmt.Errorf("invalid 'y' rule format")
+		if mods.count != 0 || mods.until != "" {
+			return "", modifiers{}, fmt.Errorf("a repeat rule can have only one of count and until")
 		}
 
-		return nextDateByYear(taskDate, now), nil
-	} else {
-		return "", fmt.Errorf("unsupported format")
+		if keyword == "count" {
+			count, err := strconv.Atoi(last)
+			if err != nil || count < 1 {
+				return "", modifiers{}, fmt.Errorf("invalid number of occurrences")
+			}
+
+			mods.count = count
+		} else {
+			_, err := time.Parse(DateFormat, last)
+			if err != nil {
+				return "", modifiers{}, fmt.Errorf("invalid until date")
+			}
+
+			mods.until = last
+		}
+
+		parts = parts[:len(parts)-2]
+	}
+
+	if mods.unshifted != "" && mods.shift == 0 {
+		return "", modifiers{}, fmt.Errorf("from only goes with workday")
+	}
+
+	return strings.Join(parts, " "), mods, nil
+}
+
+func (mods modifiers) String() string {
+	var result string
+
+	switch mods.shift {
+	case 1:
+		result += " workday"
+	case -1:
+		result += " workday-"
+	}
+
+	if mods.count > 0 {
+		result += fmt.Sprintf(" count %d", mods.count)
+	}
+
+	if mods.until != "" {
+		result += " until " + mods.until
 	}
+
+	if mods.unshifted != "" {
+		result += " from " + mods.unshifted
+	}
+
+	return result
 }
 
-func nextDateByDays(taskDate, now time.Time, days int) string {
+// nextWorkdayTime is nextDateTime from base with the workday shift applied.
+// It returns the next occurrence and the date it was moved from. Occurrences
+// that end up on or before the task date or "now" after the shift are
+// passed.
+func nextWorkdayTime(now time.Time, base, date, clock string, ruleType RuleType, rule Rule, shift int) (string, string, string, error) {
+	next, nextTime, err := nextDateTime(now, base, clock, ruleType, rule)
+	if err != nil || shift == 0 {
+		return next, next, nextTime, err
+	}
+
 	for {
-		taskDate = taskDate.AddDate(0, 0, days)
-		if taskDate.After(now) {
-			return taskDate.Format(DateFormat)
+		shifted, err := shiftToWorkday(next, shift)
+		if err != nil {
+			return "", "", "", err
+		}
+
+		if shifted > date && isAfter(now, shifted, nextTime, ruleType.Timed) {
+			return shifted, next, nextTime, nil
+		}
+
+		current, err := occurrenceTime(next, nextTime)
+		if err != nil {
+			return "", "", "", err
+		}
+
+		next, nextTime, err = nextDateTime(current, next, nextTime, ruleType, rule)
+		if err != nil {
+			return "", "", "", err
+		}
+	}
+}
+
+func occurrenceTime(date, clock string) (time.Time, error) {
+	if clock == "" {
+		return time.Parse(DateFormat, date)
+	}
+
+	return time.Parse(DateFormat+TimeFormat, date+clock)
+}
+
+func isAfter(now time.Time, date, clock string, withTime bool) bool {
+	if !withTime {
+		return date > now.Format(DateFormat)
+	}
+
+	if clock == "" {
+		clock = "00:00"
+	}
+
+	return date+clock > now.Format(DateFormat+TimeFormat)
+}
+
+func nextDateTime(now time.Time, date, clock string, ruleType RuleType, rule Rule) (string, string, error) {
+	start, err := time.Parse(DateFormat, date)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid date format: %v", err)
+	}
+
+	if ruleType.Timed {
+		start, err = occurrenceTime(date, clock)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid time format")
+		}
+	}
+
+	next, err := rule.Next(start, now)
+	if err != nil {
+		return "", "", err
+	}
+
+	if ruleType.Timed {
+		return next.Format(DateFormat), next.Format(TimeFormat), nil
+	}
+
+	return next.Format(DateFormat), clock, nil
+}
+
+// nextDateByDays jumps straight to the first date after today that is a
+// whole number of intervals after the task date.
+func nextDateByDays(taskDate, now time.Time, days int) time.Time {
+	steps := 1
+	if today := dateOf(now); !taskDate.After(today) {
+		steps = int(daysBetween(taskDate, today)/int64(days)) + 1
+	}
+
+	return taskDate.AddDate(0, 0, steps*days)
+}
+
+// nextDateByYear jumps straight to the first anniversary after today. A task
+// on February 29 moves to March 1 and stays there, as adding one year at a
+// time would do.
+func nextDateByYear(taskDate, now time.Time) time.Time {
+	if taskDate.Month() == time.February && taskDate.Day() == 29 {
+		taskDate = taskDate.AddDate(0, 0, 1)
+	}
+
+	today := dateOf(now)
+
+	years := today.Year() - taskDate.Year()
+	if years < 1 {
+		years = 1
+	}
+
+	next := taskDate.AddDate(years, 0, 0)
+	if !next.After(today) {
+		next = taskDate.AddDate(years+1, 0, 0)
+	}
+
+	return next
+}
+
+// daysBetween works on whole days, unlike time.Sub it doesn't overflow for
+// dates centuries apart.
+func daysBetween(from, to time.Time) int64 {
+	return (to.Unix() - from.Unix()) / (24 * 60 * 60)
+}
+
+func parseWeekdays(list string) (map[time.Weekday]bool, error) {
+	weekdays := make(map[time.Weekday]bool)
+
+	for _, value := range strings.Split(list, ",") {
+		day, err := strconv.Atoi(value)
+		if err != nil || day < 1 || day > 7 {
+			return nil, fmt.Errorf("invalid day of the week")
 		}
+
+		weekdays[time.Weekday(day%7)] = true
 	}
+
+	return weekdays, nil
 }
 
-func nextDateByYear(taskDate, now time.Time) string {
+func nextDateByWeekdays(taskDate, now time.Time, weekdays map[time.Weekday]bool) time.Time {
+	if now.After(taskDate) {
+		taskDate = now
+	}
+
 	for {
-		taskDate = taskDate.AddDate(1, 0, 0)
-		if taskDate.After(now) {
-			return taskDate.Format(DateFormat)
+		taskDate = taskDate.AddDate(0, 0, 1)
+		if weekdays[taskDate.Weekday()] {
+			return dateOf(taskDate)
+		}
+	}
+}
+
+func parseMonthDays(list string) ([]int, error) {
+	var days []int
+
+	for _, value := range strings.Split(list, ",") {
+		day, err := strconv.Atoi(value)
+		if err != nil || day < -2 || day == 0 || day > 31 {
+			return nil, fmt.Errorf("invalid day of the month")
 		}
+
+		days = append(days, day)
 	}
+
+	return days, nil
+}
+
+func parseMonths(list string) (map[time.Month]bool, error) {
+	months := make(map[time.Month]bool)
+
+	for _, value := range strings.Split(list, ",") {
+		month, err := strconv.Atoi(value)
+		if err != nil || month < 1 || month > 12 {
+			return nil, fmt.Errorf("invalid month")
+		}
+
+		months[time.Month(month)] = true
+	}
+
+	return months, nil
+}
+
+func nextDateByMonthDays(taskDate, now time.Time, days []int, months map[time.Month]bool) (time.Time, error) {
+	if now.After(taskDate) {
+		taskDate = now
+	}
+
+	year, month, _ := taskDate.Date()
+	for i := 0; i <= maxMonthSearch; i++ {
+		first := time.Date(year, month+time.Month(i), 1, 0, 0, 0, 0, time.UTC)
+		if len(months) > 0 && !months[first.Month()] {
+			continue
+		}
+
+		var next time.Time
+		for _, day := range monthDaysIn(first, days) {
+			date := first.AddDate(0, 0, day-1)
+			if date.Format(DateFormat) > taskDate.Format(DateFormat) && (next.IsZero() || date.Before(next)) {
+				next = date
+			}
+		}
+
+		if !next.IsZero() {
+			return next, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("the 'm' rule never matches a date")
+}
+
+func monthDaysIn(first time.Time, days []int) []int {
+	last := first.AddDate(0, 1, -1).Day()
+
+	var result []int
+	for _, day := range days {
+		if day < 0 {
+			day = last + day + 1
+		}
+
+		if day <= last {
+			result = append(result, day)
+		}
+	}
+
+	return result
 }
@@ -0,0 +1,284 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// loopNextDateByDays and loopNextDateByYear are the original implementations,
+// which add one interval at a time. The closed forms must agree with them.
+func loopNextDateByDays(taskDate, now time.Time, days int) string {
+	for {
+		taskDate = taskDate.AddDate(0, 0, days)
+		if taskDate.After(now) {
+			return taskDate.Format(DateFormat)
+		}
+	}
+}
+
+func loopNextDateByYear(taskDate, now time.Time) string {
+	for {
+		taskDate = taskDate.AddDate(1, 0, 0)
+		if taskDate.After(now) {
+			return taskDate.Format(DateFormat)
+		}
+	}
+}
+
+// fuzzDates turns fuzzer input into a task date and a "now" at most about
+// two centuries away from 2000-01-01, so that the loops stay fast.
+func fuzzDates(taskOffset, nowOffset int32, seconds uint32) (time.Time, time.Time) {
+	const span = 200 * 366
+
+	base := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	taskDate := base.AddDate(0, 0, int(taskOffset%span))
+	now := base.AddDate(0, 0, int(nowOffset%span)).Add(time.Duration(seconds%(24*60*60)) * time.Second)
+
+	return taskDate, now
+}
+
+func FuzzNextDateByDays(f *testing.F) {
+	f.Add(int32(0), int32(0), uint32(0), uint16(1))
+	f.Add(int32(-9000), int32(8800), uint32(3600), uint16(7))
+	f.Add(int32(5000), int32(-100), uint32(86399), uint16(400))
+	f.Add(int32(59), int32(60), uint32(0), uint16(365))
+
+	f.Fuzz(func(t *testing.T, taskOffset, nowOffset int32, seconds uint32, interval uint16) {
+		days := int(interval%400) + 1
+		taskDate, now := fuzzDates(taskOffset, nowOffset, seconds)
+
+		want := loopNextDateByDays(taskDate, now, days)
+		got := nextDateByDays(taskDate, now, days).Format(DateFormat)
+		if got != want {
+			t.Errorf("nextDateByDays(%s, %s, %d) = %s, want %s", taskDate.Format(DateFormat), now, days, got, want)
+		}
+	})
+}
+
+func FuzzNextDateByYear(f *testing.F) {
+	f.Add(int32(0), int32(0), uint32(0))
+	f.Add(int32(59), int32(3000), uint32(0))
+	f.Add(int32(59), int32(-3000), uint32(43200))
+	f.Add(int32(-40000), int32(8800), uint32(1))
+
+	f.Fuzz(func(t *testing.T, taskOffset, nowOffset int32, seconds uint32) {
+		taskDate, now := fuzzDates(taskOffset, nowOffset, seconds)
+
+		want := loopNextDateByYear(taskDate, now)
+		got := nextDateByYear(taskDate, now).Format(DateFormat)
+		if got != want {
+			t.Errorf("nextDateByYear(%s, %s) = %s, want %s", taskDate.Format(DateFormat), now, got, want)
+		}
+	})
+}
+
+func TestNextDateFarAway(t *testing.T) {
+	now := time.Date(2024, time.January, 26, 0, 0, 0, 0, time.UTC)
+
+	tbl := []struct {
+		date   string
+		repeat string
+		want   string
+	}{
+		{"10000101", "d 1", "20240127"},
+		{"10000101", "d 400", "20250127"},
+		{"16000229", "y", "20240301"},
+		{"16890220", "y", "20240220"},
+		{"99990101", "d 7", "99990108"},
+	}
+	for _, v := range tbl {
+		got, err := NextDate(now, v.date, v.repeat)
+		if err != nil {
+			t.Errorf("NextDate(%q, %q) returned %v", v.date, v.repeat, err)
+			continue
+		}
+
+		if got != v.want {
+			t.Errorf("NextDate(%q, %q) = %s, want %s", v.date, v.repeat, got, v.want)
+		}
+	}
+}
+
+type paydayRule struct{}
+
+func (rule paydayRule) Next(start, now time.Time) (time.Time, error) {
+	return nextDateByMonthDays(start, now, []int{15, -1}, nil)
+}
+
+func (rule paydayRule) Describe() string {
+	return "every payday"
+}
+
+// unregisterRule undoes RegisterRule, so that tests can run again.
+func unregisterRule(name string) {
+	for i, ruleType := range ruleTypes {
+		if ruleType.Name == name {
+			ruleTypes = append(ruleTypes[:i:i], ruleTypes[i+1:]...)
+			return
+		}
+	}
+}
+
+// TestNextDateByRRuleFarDates counts INTERVAL periods between dates more
+// than time.Duration can hold apart.
+func TestNextDateByRRuleFarDates(t *testing.T) {
+	tbl := []struct {
+		now    time.Time
+		date   string
+		repeat string
+		want   string
+	}{
+		{time.Date(2024, time.January, 26, 0, 0, 0, 0, time.UTC), "16000101", "RRULE:FREQ=DAILY;INTERVAL=3", "20240128"},
+		{time.Date(2024, time.January, 26, 0, 0, 0, 0, time.UTC), "16000101", "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO", "20240129"},
+		{time.Date(2400, time.January, 1, 0, 0, 0, 0, time.UTC), "20240101", "RRULE:FREQ=DAILY;INTERVAL=3", "24000104"},
+		{time.Date(2400, time.January, 1, 0, 0, 0, 0, time.UTC), "20240101", "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO", "24000110"},
+	}
+	for _, v := range tbl {
+		got, err := NextDate(v.now, v.date, v.repeat)
+		if err != nil {
+			t.Errorf("NextDate(%q, %q) returned %v", v.date, v.repeat, err)
+			continue
+		}
+
+		if got != v.want {
+			t.Errorf("NextDate(%q, %q) = %s, want %s", v.date, v.repeat, got, v.want)
+		}
+	}
+
+	// The dates above come out right even from too few periods, which only
+	// makes the search longer, so the count is checked as well.
+	start := time.Date(1600, time.January, 1, 0, 0, 0, 0, time.UTC)
+	date := time.Date(2024, time.January, 26, 0, 0, 0, 0, time.UTC)
+	for repeat, want := range map[string]int{"FREQ=DAILY;INTERVAL=3": 51629, "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO": 11063} {
+		rule, err := parseRRule(repeat)
+		if err != nil {
+			t.Fatalf("parseRRule(%q) returned %v", repeat, err)
+		}
+
+		if got := rule.periodsBetween(start, date); got != want {
+			t.Errorf("periodsBetween for %q = %d, want %d", repeat, got, want)
+		}
+	}
+}
+
+func TestRegisterRule(t *testing.T) {
+	RegisterRule(RuleType{Name: "payday", Parse: func(rule string) (Rule, error) {
+		if rule != "payday" {
+			return nil, fmt.Errorf("invalid 'payday' rule format")
+		}
+
+		return paydayRule{}, nil
+	}})
+	t.Cleanup(func() { unregisterRule("payday") })
+
+	// Five words, like a cron expression, which must not take it over.
+	RegisterRule(RuleType{Name: "paydays", Parse: func(rule string) (Rule, error) {
+		if rule != "paydays 15 and last day" {
+			return nil, fmt.Errorf("invalid 'paydays' rule format")
+		}
+
+		return paydayRule{}, nil
+	}})
+	t.Cleanup(func() { unregisterRule("paydays") })
+
+	now := time.Date(2024, time.January, 26, 0, 0, 0, 0, time.UTC)
+
+	tbl := []struct {
+		date   string
+		repeat string
+		want   string
+	}{
+		{"20240110", "payday", "20240131"},
+		{"20240131", "payday", "20240215"},
+		{"20240110", "payday workday-", "20240131"},
+		{"20240301", "payday count 2", "20240315"},
+		{"20240110", "paydays 15 and last day", "20240131"},
+	}
+	for _, v := range tbl {
+		got, err := NextDate(now, v.date, v.repeat)
+		if err != nil {
+			t.Errorf("NextDate(%q, %q) returned %v", v.date, v.repeat, err)
+			continue
+		}
+
+		if got != v.want {
+			t.Errorf("NextDate(%q, %q) = %s, want %s", v.date, v.repeat, got, v.want)
+		}
+	}
+
+	_, err := NextDate(now, "20240110", "payday 5")
+	if err == nil {
+		t.Errorf("NextDate accepted an invalid payday rule")
+	}
+
+	// Rules that only know English are described in English.
+	got, err := DescribeRepeat("payday", LangRussian)
+	if err != nil || got != "every payday" {
+		t.Errorf("DescribeRepeat(%q, %s) = %q, %v, want %q", "payday", LangRussian, got, err, "every payday")
+	}
+}
+
+func BenchmarkNextDateByDays(b *testing.B) {
+	now := time.Date(2024, time.January, 26, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < b.N; i++ {
+		_, err := NextDate(now, "18000101", "d 1")
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNextDateByYear(b *testing.B) {
+	now := time.Date(2024, time.January, 26, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < b.N; i++ {
+		_, err := NextDate(now, "16000229", "y")
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoopNextDateByDays(b *testing.B) {
+	now := time.Date(2024, time.January, 26, 0, 0, 0, 0, time.UTC)
+	taskDate := time.Date(1800, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < b.N; i++ {
+		loopNextDateByDays(taskDate, now, 1)
+	}
+}
+
+func TestAdvanceWorkday(t *testing.T) {
+	tbl := []struct {
+		date       string
+		repeat     string
+		next       string
+		nextRepeat string
+	}{
+		{"20220101", "y workday", "20230102", "y workday from 20230101"},
+		// 2024-01-01 is a Monday, so the series is back on its day.
+		{"20230102", "y workday from 20230101", "20240101", "y workday"},
+		// A date that wasn't moved from the from date, as after an edit,
+		// is used as it is.
+		{"20240105", "y workday from 20230101", "20250106", "y workday from 20250105"},
+		{"20240301", "m 30 workday count 3", "20240401", "m 30 workday count 2 from 20240330"},
+		{"20240401", "m 30 workday count 2 from 20240330", "20240430", "m 30 workday count 1"},
+		{"20240329", "m 30 workday- from 20240330", "20240430", "m 30 workday-"},
+	}
+	for _, v := range tbl {
+		now, _ := time.Parse(DateFormat, v.date)
+		next, _, nextRepeat, err := Advance(now, v.date, "", v.repeat, "")
+		if err != nil || next != v.next || nextRepeat != v.nextRepeat {
+			t.Errorf("Advance(%q, %q) returned %s, %q, %v, want %s, %q", v.date, v.repeat, next, nextRepeat, err, v.next, v.nextRepeat)
+		}
+	}
+
+	for _, repeat := range []string{"y from 20230101", "y workday from 2023", "y workday from 20230101 from 20230101"} {
+		err := ValidateRepeat(repeat)
+		if err == nil {
+			t.Errorf("ValidateRepeat(%q) accepted it", repeat)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgenda(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("DELETE FROM scheduler")
+	assert.NoError(t, err)
+
+	// 10.01.2024 is a Wednesday. The tasks are in the past, so they go in directly.
+	tbl := []struct {
+		date, time, timezone, title string
+	}{
+		{"20240101", "", "", "Просроченная"},
+		{"20240109", "", "", "Вчерашняя"},
+		{"20240110", "", "", "Сегодняшняя"},
+		{"20240110", "23:30", "UTC", "Поздний звонок"},
+		{"20240111", "", "", "Завтрашняя"},
+		{"20240114", "", "", "Воскресная"},
+		{"20240115", "", "", "Следующая неделя"},
+	}
+	for _, v := range tbl {
+		_, err = db.Exec("INSERT INTO scheduler (date, time, timezone, title, comment, repeat) VALUES (?, ?, ?, ?, '', '')",
+			v.date, v.time, v.timezone, v.title)
+		assert.NoError(t, err)
+	}
+
+	titles := func(m map[string]any, group string) []string {
+		var result []string
+		items, _ := m[group].([]any)
+		for _, item := range items {
+			result = append(result, item.(map[string]any)["title"].(string))
+		}
+		return result
+	}
+
+	m := getWithLanguage(t, "api/agenda?now=20240110&tz=UTC", "")
+	assert.Empty(t, m["error"])
+	assert.Equal(t, "20240110", m["date"])
+	assert.Equal(t, []string{"Просроченная", "Вчерашняя"}, titles(m, "overdue"))
+	assert.Equal(t, []string{"Сегодняшняя", "Поздний звонок"}, titles(m, "today"))
+	assert.Equal(t, []string{"Завтрашняя"}, titles(m, "tomorrow"))
+	assert.Equal(t, []string{"Воскресная"}, titles(m, "week"))
+	assert.Equal(t, []string{"Следующая неделя"}, titles(m, "later"))
+
+	overdue := m["overdue"].([]any)
+	assert.Equal(t, float64(9), overdue[0].(map[string]any)["days_late"])
+	assert.Equal(t, float64(1), overdue[1].(map[string]any)["days_late"])
+
+	// In Tokyo the late call is already on the 11th.
+	m = getWithLanguage(t, "api/agenda?now=20240110&tz=Asia/Tokyo", "")
+	assert.Equal(t, []string{"Сегодняшняя"}, titles(m, "today"))
+	assert.Equal(t, []string{"Поздний звонок", "Завтрашняя"}, titles(m, "tomorrow"))
+
+	// On Sunday there is nothing left of the week after tomorrow.
+	m = getWithLanguage(t, "api/agenda?now=20240114&tz=UTC", "")
+	assert.Equal(t, []string{"Воскресная"}, titles(m, "today"))
+	assert.Equal(t, []string{"Следующая неделя"}, titles(m, "tomorrow"))
+	assert.Empty(t, titles(m, "week"))
+	assert.Empty(t, titles(m, "later"))
+
+	for _, query := range []string{"now=2024-01-10", "tz=Mars/Olympus"} {
+		m = getWithLanguage(t, "api/agenda?"+query, "")
+		assert.NotEmpty(t, m["error"], query)
+	}
+}
+
+func TestChangeOverdue(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	date := time.Now().AddDate(0, 0, -5).Format(`20060102`)
+	res, err := db.Exec("INSERT INTO scheduler (date, title, comment, repeat) VALUES (?, 'Сдать отчёт', '', '')", date)
+	assert.NoError(t, err)
+	id, err := res.LastInsertId()
+	assert.NoError(t, err)
+
+	var task Task
+	update := func(values map[string]any) {
+		values["id"] = fmt.Sprint(id)
+		ret, err := postJSON("api/task", values, http.MethodPut)
+		assert.NoError(t, err)
+		assert.Empty(t, ret)
+
+		err = db.Get(&task, `SELECT * FROM scheduler WHERE id=?`, id)
+		assert.NoError(t, err)
+	}
+
+	// Editing an overdue task leaves it overdue.
+	update(map[string]any{"date": date, "title": "Сдать квартальный отчёт"})
+	assert.Equal(t, date, task.Date)
+	assert.Equal(t, "Сдать квартальный отчёт", task.Title)
+
+	// Moving it to another past date still moves it to today.
+	update(map[string]any{"date": time.Now().AddDate(0, 0, -1).Format(`20060102`), "title": "Сдать квартальный отчёт"})
+	assert.Equal(t, time.Now().Format(`20060102`), task.Date)
+
+	_, err = db.Exec("DELETE FROM scheduler WHERE id = ?", id)
+	assert.NoError(t, err)
+}
@@ -13,9 +13,18 @@ import (
 type Task struct {
 	ID      int64  `db:"id"`
 	Date    string `db:"date"`
+	Time    string `db:"time"`
 	Title   string `db:"title"`
 	Comment string `db:"comment"`
 	Repeat  string `db:"repeat"`
+	Skip    string `db:"skip"`
+	Anchor  string `db:"anchor"`
+
+	Duration string `db:"duration"`
+	Timezone string `db:"timezone"`
+	Priority int64  `db:"priority"`
+	StartsAt string `db:"starts_at"`
+	OwnerID  int64  `db:"owner_id"`
 }
 
 func count(db *sqlx.DB) (int, error) {
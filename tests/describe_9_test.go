@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getWithLanguage(t *testing.T, path, lang string) map[string]any {
+	req, err := http.NewRequest(http.MethodGet, getURL(path), nil)
+	assert.NoError(t, err)
+	req.Header.Set("Accept-Language", lang)
+	if len(Token) > 0 {
+		req.AddCookie(&http.Cookie{Name: "token", Value: Token})
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	var m map[string]any
+	err = json.Unmarshal(body, &m)
+	assert.NoError(t, err)
+	return m
+}
+
+func TestDescribeRepeat(t *testing.T) {
+	tbl := []struct {
+		repeat string
+		lang   string
+		want   string
+	}{
+		{"d 7", "en-US,en;q=0.9", "every 7 days"},
+		{"d 7", "ru-RU,ru;q=0.9", "каждые 7 дней"},
+		{"y", "ru", "ежегодно"},
+		{"w 1,4", "de", "every Monday, Thursday"},
+	}
+	for _, v := range tbl {
+		m := getWithLanguage(t, "api/describe?repeat="+url.QueryEscape(v.repeat), v.lang)
+		assert.Equal(t, v.want, m["text"], "{%q, %q}", v.repeat, v.lang)
+	}
+
+	m := getWithLanguage(t, "api/describe?repeat=ooops", "en")
+	assert.NotEmpty(t, m["error"])
+}
+
+func TestRepeatText(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	id := addTask(t, task{
+		date:   time.Now().Format(`20060102`),
+		title:  "Полить цветы",
+		repeat: "d 3",
+	})
+
+	m := getWithLanguage(t, "api/task?id="+id, "ru")
+	assert.Equal(t, "каждые 3 дня", m["repeat_text"])
+
+	m = getWithLanguage(t, "api/task?id="+id, "en")
+	assert.Equal(t, "every 3 days", m["repeat_text"])
+
+	_, err := db.Exec("DELETE FROM scheduler WHERE id = ?", id)
+	assert.NoError(t, err)
+}
+
+func TestParseRepeat(t *testing.T) {
+	tbl := []struct {
+		text string
+		want string
+	}{
+		{"every other Friday", "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=FR"},
+		{"каждый понедельник", "w 1"},
+		{"last day of the month", "m -1"},
+		{"every 7 days", "d 7"},
+	}
+	for _, v := range tbl {
+		m := getWithLanguage(t, "api/repeat/parse?text="+url.QueryEscape(v.text), "en")
+		assert.Equal(t, v.want, m["repeat"], "%q", v.text)
+		assert.NotEmpty(t, m["repeat_text"], "%q", v.text)
+	}
+
+	m := getWithLanguage(t, "api/repeat/parse?text="+url.QueryEscape("now and then"), "en")
+	assert.NotEmpty(t, m["error"])
+}
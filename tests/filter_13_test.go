@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("DELETE FROM scheduler")
+	assert.NoError(t, err)
+
+	now := time.Now()
+	day := func(days int) string {
+		return now.AddDate(0, 0, days).Format(`20060102`)
+	}
+	addTask(t, task{date: day(0), title: "Weekly report", repeat: "w 1,2,3,4,5,6,7"})
+	addTask(t, task{date: day(1), title: "Квартальный отчёт", comment: "Отправить в бухгалтерию", repeat: "d 90"})
+	addTask(t, task{date: day(3), title: "Dentist"})
+	addTask(t, task{date: day(10), title: "Annual report", repeat: "y"})
+
+	tbl := []struct {
+		filter string
+		count  int
+	}{
+		{`date>=` + day(1), 3},
+		{`date>=` + day(1) + ` AND date<=` + day(3), 2},
+		{`date>today`, 3},
+		{`date<=today+3`, 3},
+		{`date:today`, 1},
+		{`date:tomorrow OR date:today`, 2},
+		{`repeat:any`, 3},
+		{`repeat:none`, 1},
+		{`repeat:w OR repeat:y`, 2},
+		{`title~"report"`, 2},
+		{`title~REPORT repeat:any`, 2},
+		{`title~"отчёт" AND comment~бухгалтерию`, 1},
+		{`NOT title~report`, 2},
+		{`(title~report OR title~dentist) AND date>today`, 2},
+		{`date>=today AND repeat:any AND title~"report"`, 2},
+		{`title="Dentist"`, 1},
+		{`title!~e`, 1},
+		{``, 4},
+	}
+	for _, v := range tbl {
+		m := getWithLanguage(t, "api/tasks?filter="+url.QueryEscape(v.filter), "")
+		assert.Empty(t, m["error"], "%q", v.filter)
+		tasks, _ := m["tasks"].([]any)
+		assert.Len(t, tasks, v.count, "%q", v.filter)
+	}
+
+	for _, filter := range []string{
+		`date>=tomorrow'`,
+		`title~"report`,
+		`colour=red`,
+		`date~today`,
+		`date>=`,
+		`(title~report`,
+		`title~report)`,
+		`repeat:sometimes OR`,
+		`time>=9`,
+		`title="x"; DROP TABLE scheduler`,
+	} {
+		m := getWithLanguage(t, "api/tasks?filter="+url.QueryEscape(filter), "")
+		assert.NotEmpty(t, m["error"], "%q", filter)
+	}
+}
@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func listNames(t *testing.T) []string {
+	m := getWithLanguage(t, "api/lists", "")
+	lists, _ := m["lists"].([]any)
+
+	var names []string
+	for _, list := range lists {
+		names = append(names, list.(map[string]any)["name"].(string))
+	}
+	return names
+}
+
+func TestLists(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("DELETE FROM scheduler")
+	assert.NoError(t, err)
+	_, err = db.Exec("DELETE FROM lists")
+	assert.NoError(t, err)
+
+	now := time.Now()
+	day := func(days int) string {
+		return now.AddDate(0, 0, days).Format(`20060102`)
+	}
+	addTask(t, task{date: day(0), title: "Оплатить счета", repeat: "m 10"})
+	addTask(t, task{date: day(0), title: "Позвонить маме"})
+	addTask(t, task{date: day(2), title: "Налоговая декларация", comment: "finance", repeat: "y"})
+	addTask(t, task{date: day(5), title: "Купить билеты"})
+	// Tasks can't be saved with a past date, so the overdue one goes in directly.
+	_, err = db.Exec("INSERT INTO scheduler (date, title, comment, repeat) VALUES (?, 'Сдать отчёт', '', '')", day(-3))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"Today", "Upcoming", "Overdue", "No repeat"}, listNames(t))
+
+	count := func(name string) int {
+		m := getWithLanguage(t, "api/lists/"+url.PathEscape(name)+"/tasks", "")
+		assert.Empty(t, m["error"], name)
+		tasks, _ := m["tasks"].([]any)
+		return len(tasks)
+	}
+	assert.Equal(t, 2, count("Today"))
+	assert.Equal(t, 2, count("upcoming"))
+	assert.Equal(t, 1, count("Overdue"))
+	assert.Equal(t, 3, count("No repeat"))
+
+	ret, err := postJSON("api/lists", map[string]any{"name": "Recurring finance", "filter": `repeat:any AND comment~finance`}, http.MethodPost)
+	assert.NoError(t, err)
+	assert.Empty(t, ret["error"])
+	assert.Equal(t, 1, count("Recurring finance"))
+
+	// Saving a list again replaces it.
+	ret, err = postJSON("api/lists", map[string]any{"name": "recurring finance", "filter": `repeat:any`}, http.MethodPost)
+	assert.NoError(t, err)
+	assert.Empty(t, ret["error"])
+	assert.Equal(t, 2, count("Recurring finance"))
+	assert.Equal(t, []string{"Today", "Upcoming", "Overdue", "No repeat", "recurring finance"}, listNames(t))
+
+	for _, list := range []map[string]any{
+		{"name": "", "filter": "repeat:any"},
+		{"name": "Today", "filter": "repeat:any"},
+		{"name": "a/b", "filter": "repeat:any"},
+		{"name": "Broken", "filter": ""},
+		{"name": "Broken", "filter": "colour=red"},
+	} {
+		ret, err = postJSON("api/lists", list, http.MethodPost)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, ret["error"], "%v", list)
+	}
+
+	m := getWithLanguage(t, "api/lists/Unknown/tasks", "")
+	assert.NotEmpty(t, m["error"])
+
+	ret, err = postJSON("api/lists/Today", nil, http.MethodDelete)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ret["error"])
+
+	ret, err = postJSON("api/lists/"+url.PathEscape("Recurring finance"), nil, http.MethodDelete)
+	assert.NoError(t, err)
+	assert.Empty(t, ret["error"])
+	assert.Len(t, listNames(t), 4)
+}
@@ -36,6 +36,28 @@ func TestNextDate(t *testing.T) {
 		{"20240320", "d 401", ""},
 		{"20231225", "d 12", `20240130`},
 		{"20240228", "d 1", "20240229"},
+		{"20240101", "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=FR", "20240202"},
+		{"20240101", "RRULE:FREQ=MONTHLY;BYDAY=2TU", "20240213"},
+		{"20240101", "RRULE:FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1", "20240131"},
+		{"20240131", "RRULE:FREQ=MONTHLY", "20240331"},
+		{"20240101", "RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=4TH", "20241128"},
+		{"20240101", "RRULE:FREQ=DAILY;COUNT=5", ""},
+		{"20240101", "RRULE:FREQ=DAILY;UNTIL=20240130T000000Z", "20240127"},
+		{"20240101", "RRULE:FREQ=WEEKLY;BYDAY=2TU", ""},
+		{"20240101", "RRULE:INTERVAL=2", ""},
+		{"20240120", "0 9,17 * * 1-5", "20240126 09:00"},
+		{"20240126", "@weekly", "20240128 00:00"},
+		{"20240126", "0 0 30 2 *", ""},
+		{"20240101", "d 7 count 3", ""},
+		{"20240101", "d 7 count 5", "20240129"},
+		{"20240120", "w 1 until 20240131", "20240129"},
+		{"20240120", "w 1 until 20240128", ""},
+		{"20240120", "w 1 count 0", ""},
+		{"20240101", "RRULE:FREQ=DAILY count 2", ""},
+		{"20240126", "m 27 workday", "20240129"},
+		{"20240126", "m 27 workday-", "20240227"},
+		{"20240126", "m 27 workday- count 1", ""},
+		{"20240126", "d 7 workday workday-", ""},
 	}
 	check := func() {
 		for _, v := range tbl {
@@ -77,3 +99,32 @@ func TestNextDate(t *testing.T) {
 	}
 	check()
 }
+
+func TestNextDateAnchor(t *testing.T) {
+	if !FullNextDate {
+		return
+	}
+	tbl := []struct {
+		date   string
+		repeat string
+		anchor string
+		want   string
+	}{
+		{"20240101", "d 3", "scheduled", "20240128"},
+		{"20240101", "d 3", "completion", "20240129"},
+		{"20240201", "d 3", "completion", "20240129"},
+		{"20240101", "d 3", "sometimes", ""},
+	}
+	for _, v := range tbl {
+		urlPath := fmt.Sprintf("api/nextdate?now=20240126&date=%s&repeat=%s&anchor=%s",
+			url.QueryEscape(v.date), url.QueryEscape(v.repeat), url.QueryEscape(v.anchor))
+		get, err := getBody(urlPath)
+		assert.NoError(t, err)
+		next := strings.TrimSpace(string(get))
+		_, err = time.Parse("20060102", next)
+		if err != nil && len(v.want) == 0 {
+			continue
+		}
+		assert.Equal(t, v.want, next, `{%q, %q, %q}`, v.date, v.repeat, v.anchor)
+	}
+}
@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type occurrences struct {
+	date   string
+	repeat string
+	count  int
+	want   []string
+}
+
+func TestOccurrences(t *testing.T) {
+	tbl := []occurrences{
+		{"20240101", "m -1", 4, []string{"20240131", "20240229", "20240331", "20240430"}},
+		{"20240201", "d 7 count 3", 5, []string{"20240201", "20240208", "20240215"}},
+		{"20240126", "w 1,5", 3, []string{"20240126", "20240129", "20240202"}},
+		{"20240120", "d 3", 3, []string{"20240126", "20240129", "20240201"}},
+		{"20240101", "w 5", 2, []string{"20240126", "20240202"}},
+		{"20240126", "", 3, []string{"20240126"}},
+		{"20240126", "y", 0, nil},
+		{"20240126", "ooops", 3, nil},
+	}
+	for _, v := range tbl {
+		urlPath := fmt.Sprintf("api/occurrences?from=20240126&date=%s&repeat=%s&count=%d",
+			url.QueryEscape(v.date), url.QueryEscape(v.repeat), v.count)
+		body, err := requestJSON(urlPath, nil, http.MethodGet)
+		assert.NoError(t, err)
+
+		var m struct {
+			Occurrences []struct {
+				Date string `json:"date"`
+			} `json:"occurrences"`
+			Error string `json:"error"`
+		}
+		err = json.Unmarshal(body, &m)
+		assert.NoError(t, err)
+
+		if v.want == nil {
+			assert.NotEmpty(t, m.Error, "Ожидается ошибка для %v", v)
+			continue
+		}
+		var dates []string
+		for _, o := range m.Occurrences {
+			dates = append(dates, o.Date)
+		}
+		assert.Equal(t, v.want, dates, `{%q, %q}`, v.date, v.repeat)
+	}
+}
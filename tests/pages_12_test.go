@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getPage(t *testing.T, query url.Values) ([]map[string]string, int, string) {
+	req, err := http.NewRequest(http.MethodGet, getURL("api/tasks?"+query.Encode()), nil)
+	assert.NoError(t, err)
+	if len(Token) > 0 {
+		req.AddCookie(&http.Cookie{Name: "token", Value: Token})
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	var m map[string][]map[string]string
+	err = json.Unmarshal(body, &m)
+	assert.NoError(t, err)
+
+	total, err := strconv.Atoi(resp.Header.Get("X-Total-Count"))
+	assert.NoError(t, err)
+	return m["tasks"], total, resp.Header.Get("X-Next-Cursor")
+}
+
+func TestTasksPages(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("DELETE FROM scheduler")
+	assert.NoError(t, err)
+
+	now := time.Now()
+	titles := []string{"Вымыть окна", "Купить хлеб", "Allocate budget", "Забрать посылку", "Бегать", "Яблоки", "арбуз"}
+	for i, title := range titles {
+		addTask(t, task{date: now.AddDate(0, 0, len(titles)-i).Format(`20060102`), title: title})
+	}
+
+	for _, sort := range []string{"", "date", "-date", "title", "-title", "created", "-created"} {
+		var seen []string
+		cursor := ""
+		for pages := 0; pages < 10; pages++ {
+			query := url.Values{"limit": {"3"}, "sort": {sort}}
+			if cursor != "" {
+				query.Set("cursor", cursor)
+			}
+
+			tasks, total, next := getPage(t, query)
+			assert.Equal(t, len(titles), total)
+			for _, task := range tasks {
+				seen = append(seen, task["title"])
+			}
+
+			cursor = next
+			if cursor == "" {
+				break
+			}
+		}
+		assert.Len(t, seen, len(titles), "sort %q", sort)
+
+		switch sort {
+		case "title":
+			assert.Equal(t, []string{"Allocate budget", "арбуз", "Бегать", "Вымыть окна", "Забрать посылку", "Купить хлеб", "Яблоки"}, seen)
+		case "", "date", "-created":
+			assert.Equal(t, "арбуз", seen[0], "sort %q", sort)
+		case "-date", "created":
+			assert.Equal(t, "Вымыть окна", seen[0], "sort %q", sort)
+		}
+	}
+
+	_, total, next := getPage(t, url.Values{"search": {"окна"}})
+	assert.Equal(t, 1, total)
+	assert.Empty(t, next)
+
+	for _, query := range []url.Values{
+		{"limit": {"0"}},
+		{"limit": {"101"}},
+		{"sort": {"size"}},
+		{"cursor": {"ooops"}},
+	} {
+		body, err := requestJSON("api/tasks?"+query.Encode(), nil, http.MethodGet)
+		assert.NoError(t, err)
+		var m map[string]any
+		err = json.Unmarshal(body, &m)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, m["error"], "%v", query)
+	}
+
+	_, _, next = getPage(t, url.Values{"limit": {"2"}, "sort": {"title"}})
+	body, err := requestJSON("api/tasks?"+url.Values{"cursor": {next}, "sort": {"date"}}.Encode(), nil, http.MethodGet)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "error")
+}
+
+func TestTasksPriority(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("DELETE FROM scheduler")
+	assert.NoError(t, err)
+
+	date := time.Now().Format(`20060102`)
+	for i, priority := range []int{2, 0, 3, 1} {
+		m, err := postJSON("api/task", map[string]any{"date": date, "title": "Задача " + strconv.Itoa(i), "priority": priority}, http.MethodPost)
+		assert.NoError(t, err)
+		assert.Empty(t, m["error"], "%v", m)
+	}
+
+	for _, priority := range []int{-1, 4} {
+		m, err := postJSON("api/task", map[string]any{"date": date, "title": "Задача", "priority": priority}, http.MethodPost)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, m["error"], "priority %d", priority)
+	}
+
+	for _, v := range []struct {
+		query url.Values
+		want  []float64
+	}{
+		{url.Values{"sort": {"priority"}}, []float64{0, 1, 2, 3}},
+		{url.Values{"sort": {"-priority"}}, []float64{3, 2, 1, 0}},
+		{url.Values{"sort": {"-priority"}, "filter": {"priority>=2"}}, []float64{3, 2}},
+		{url.Values{"filter": {"priority:none"}}, []float64{0}},
+	} {
+		body, err := requestJSON("api/tasks?"+v.query.Encode(), nil, http.MethodGet)
+		assert.NoError(t, err)
+
+		var m struct {
+			Tasks []struct {
+				Priority float64 `json:"priority"`
+			} `json:"tasks"`
+		}
+		err = json.Unmarshal(body, &m)
+		assert.NoError(t, err)
+
+		var got []float64
+		for _, task := range m.Tasks {
+			got = append(got, task.Priority)
+		}
+		assert.Equal(t, v.want, got, "%v", v.query)
+	}
+}
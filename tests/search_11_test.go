@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearch(t *testing.T) {
+	if !Search {
+		return
+	}
+	db := openDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("DELETE FROM scheduler")
+	assert.NoError(t, err)
+
+	date := time.Now().Format(`20060102`)
+	addTask(t, task{date: date, title: "Сходить в бассейн", comment: "взять шапочку"})
+	addTask(t, task{date: date, title: "Поплавать", comment: "Бассейн с тренером"})
+	addTask(t, task{date: date, title: "Купить абонемент в бассейн", comment: "Бассейн у дома, бассейн рядом"})
+	addTask(t, task{date: date, title: "Позвонить в УК", comment: "Разобраться с горячей водой"})
+	addTask(t, task{date: date, title: "Починить <b>кран</b>", comment: ""})
+
+	tbl := []struct {
+		search string
+		count  int
+	}{
+		{"бассейн", 3},
+		{"БАССЕЙН", 3},
+		{"бассейн тренер", 1},
+		{"горяч", 1},
+		{"шапочку", 1},
+		{"уроки", 0},
+	}
+	for _, v := range tbl {
+		body, err := requestJSON("api/tasks?search="+url.QueryEscape(v.search), nil, http.MethodGet)
+		assert.NoError(t, err)
+
+		var m struct {
+			Tasks []struct {
+				Title   string `json:"title"`
+				Snippet string `json:"snippet"`
+			} `json:"tasks"`
+		}
+		err = json.Unmarshal(body, &m)
+		assert.NoError(t, err)
+		assert.Len(t, m.Tasks, v.count, "%q", v.search)
+		for _, task := range m.Tasks {
+			assert.Contains(t, task.Snippet, "<mark>", "%q", v.search)
+		}
+	}
+
+	// Snippets are HTML, so the text of tasks is escaped.
+	body, err := requestJSON("api/tasks?search="+url.QueryEscape("кран"), nil, http.MethodGet)
+	assert.NoError(t, err)
+	var m struct {
+		Tasks []struct {
+			Snippet string `json:"snippet"`
+		} `json:"tasks"`
+	}
+	err = json.Unmarshal(body, &m)
+	assert.NoError(t, err)
+	if assert.Len(t, m.Tasks, 1) {
+		assert.Equal(t, "Починить &lt;b&gt;<mark>кран</mark>&lt;/b&gt;", m.Tasks[0].Snippet)
+	}
+}
+
+func TestSearchDates(t *testing.T) {
+	if !Search {
+		return
+	}
+	db := openDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("DELETE FROM scheduler")
+	assert.NoError(t, err)
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		addTask(t, task{date: now.AddDate(0, 0, i).Format(`20060102`), title: "Задача"})
+	}
+	addTask(t, task{date: now.AddDate(0, 0, 2).Format(`20060102`), title: "Ещё одна задача"})
+
+	day := func(days int, layout string) string {
+		return now.AddDate(0, 0, days).Format(layout)
+	}
+	tbl := []struct {
+		search string
+		count  int
+	}{
+		{day(2, `02.01.2006`), 2},
+		{day(2, `2006-01-02`), 2},
+		{day(7, `02.01.2006`), 0},
+		{day(1, `02.01.2006`) + "-" + day(3, `02.01.2006`), 4},
+		{day(1, `2006-01-02`) + " - " + day(3, `2006-01-02`), 4},
+		{day(3, `2006-01-02`) + ".." + day(0, `2006-01-02`), 5},
+		{"32.01.2024", 0},
+	}
+	for _, v := range tbl {
+		tasks := getTasks(t, url.QueryEscape(v.search))
+		assert.Len(t, tasks, v.count, "%q", v.search)
+	}
+}
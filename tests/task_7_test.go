@@ -2,6 +2,7 @@ package tests
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"testing"
 	"time"
@@ -52,6 +53,148 @@ func TestDone(t *testing.T) {
 	}
 }
 
+func TestDoneSeriesEnds(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	now := time.Now()
+	id := addTask(t, task{
+		date:   now.Format(`20060102`),
+		title:  "Курс физиотерапии",
+		repeat: "d 3 count 2",
+	})
+
+	ret, err := postJSON("api/task/done?id="+id, nil, http.MethodPost)
+	assert.NoError(t, err)
+	assert.Empty(t, ret)
+
+	var task Task
+	err = db.Get(&task, `SELECT * FROM scheduler WHERE id=?`, id)
+	assert.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, 3).Format(`20060102`), task.Date)
+	assert.Equal(t, "d 3 count 1", task.Repeat)
+
+	// The last occurrence can still be edited.
+	m, err := postJSON("api/task", map[string]any{
+		"id":     id,
+		"date":   task.Date,
+		"title":  "Последний сеанс физиотерапии",
+		"repeat": task.Repeat,
+	}, http.MethodPut)
+	assert.NoError(t, err)
+	assert.Empty(t, m["error"])
+
+	ret, err = postJSON("api/task/done?id="+id, nil, http.MethodPost)
+	assert.NoError(t, err)
+	assert.Empty(t, ret)
+	notFoundTask(t, id)
+}
+
+func TestAddLastOccurrence(t *testing.T) {
+	now := time.Now()
+	today := now.Format(`20060102`)
+
+	for _, repeat := range []string{"d 3 count 1", "RRULE:FREQ=DAILY;COUNT=1", "w 1 until " + today} {
+		m, err := postJSON("api/task", map[string]any{
+			"date":   today,
+			"title":  "Разовая серия",
+			"repeat": repeat,
+		}, http.MethodPost)
+		assert.NoError(t, err)
+		assert.Empty(t, m["error"], "%q", repeat)
+		assert.NotEmpty(t, m["id"], "%q", repeat)
+	}
+
+	// A past date is replaced by the next one, so that one has to exist.
+	m, err := postJSON("api/task", map[string]any{
+		"date":   now.AddDate(0, 0, -3).Format(`20060102`),
+		"title":  "Разовая серия",
+		"repeat": "d 3 count 1",
+	}, http.MethodPost)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, m["error"])
+}
+
+func TestSkipTask(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	now := time.Now()
+	id := addTask(t, task{
+		date:   now.Format(`20060102`),
+		title:  "Еженедельная встреча",
+		repeat: "d 7",
+	})
+	holiday := now.AddDate(0, 0, 14).Format(`20060102`)
+
+	ret, err := postJSON("api/task/skip?id="+id+"&date="+holiday, nil, http.MethodPost)
+	assert.NoError(t, err)
+	assert.Empty(t, ret)
+
+	ret, err = postJSON("api/task/skip?id="+id, nil, http.MethodPost)
+	assert.NoError(t, err)
+	assert.Empty(t, ret)
+
+	var stored Task
+	err = db.Get(&stored, `SELECT * FROM scheduler WHERE id=?`, id)
+	assert.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, 7).Format(`20060102`), stored.Date)
+	assert.Equal(t, holiday, stored.Skip)
+
+	ret, err = postJSON("api/task/done?id="+id, nil, http.MethodPost)
+	assert.NoError(t, err)
+	assert.Empty(t, ret)
+
+	err = db.Get(&stored, `SELECT * FROM scheduler WHERE id=?`, id)
+	assert.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, 21).Format(`20060102`), stored.Date)
+	assert.Empty(t, stored.Skip)
+
+	id = addTask(t, task{
+		date:  now.Format(`20060102`),
+		title: "Разовая задача",
+	})
+	ret, err = postJSON("api/task/skip?id="+id, nil, http.MethodPost)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ret)
+}
+
+func TestDoneFromCompletion(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	now := time.Now()
+	ret, err := postJSON("api/task", map[string]any{
+		"date":   now.AddDate(0, 0, 5).Format(`20060102`),
+		"title":  "Полить цветы",
+		"repeat": "d 3",
+		"anchor": "completion",
+	}, http.MethodPost)
+	assert.NoError(t, err)
+	id := fmt.Sprint(ret["id"])
+
+	ret, err = postJSON("api/task/done?id="+id, nil, http.MethodPost)
+	assert.NoError(t, err)
+	assert.Empty(t, ret)
+
+	var stored Task
+	err = db.Get(&stored, `SELECT * FROM scheduler WHERE id=?`, id)
+	assert.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, 3).Format(`20060102`), stored.Date)
+	assert.Equal(t, "completion", stored.Anchor)
+
+	ret, err = postJSON("api/task", map[string]any{
+		"title":  "Полить цветы",
+		"repeat": "d 3",
+		"anchor": "sometimes",
+	}, http.MethodPost)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ret["error"])
+
+	_, err = db.Exec("DELETE FROM scheduler WHERE id = ?", id)
+	assert.NoError(t, err)
+}
+
 func TestDelTask(t *testing.T) {
 	db := openDB(t)
 	defer db.Close()
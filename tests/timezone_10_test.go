@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskTimezone(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	kiritimati, err := time.LoadLocation("Pacific/Kiritimati")
+	assert.NoError(t, err)
+
+	ret, err := postJSON("api/task", map[string]any{
+		"title":    "Созвон с островами",
+		"time":     "09:00",
+		"duration": "1h30m",
+		"timezone": "Pacific/Kiritimati",
+	}, http.MethodPost)
+	assert.NoError(t, err)
+	id := fmt.Sprint(ret["id"])
+
+	var stored Task
+	err = db.Get(&stored, `SELECT * FROM scheduler WHERE id=?`, id)
+	assert.NoError(t, err)
+
+	today := time.Now().In(kiritimati).Format(`20060102`)
+	start, err := time.ParseInLocation(`2006010215:04`, today+"09:00", kiritimati)
+	assert.NoError(t, err)
+	assert.Equal(t, today, stored.Date)
+	assert.Equal(t, "1h30m", stored.Duration)
+	assert.Equal(t, start.UTC().Format(`20060102 15:04`), stored.StartsAt)
+
+	_, err = db.Exec("DELETE FROM scheduler WHERE id = ?", id)
+	assert.NoError(t, err)
+
+	for _, v := range []map[string]any{
+		{"title": "Задача", "timezone": "Mars/Olympus"},
+		{"title": "Задача", "time": "09:00", "duration": "1 hour"},
+		{"title": "Задача", "time": "09:00", "duration": "-1h"},
+		{"title": "Задача", "duration": "1h"},
+	} {
+		ret, err := postJSON("api/task", v, http.MethodPost)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, ret["error"], "%v", v)
+	}
+}
+
+func TestTaskAcrossDST(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	ret, err := postJSON("api/task", map[string]any{
+		"date":     "20990328",
+		"time":     "09:00",
+		"title":    "Утренняя встреча",
+		"repeat":   "d 1",
+		"timezone": "Europe/Berlin",
+	}, http.MethodPost)
+	assert.NoError(t, err)
+	id := fmt.Sprint(ret["id"])
+
+	var stored Task
+	err = db.Get(&stored, `SELECT * FROM scheduler WHERE id=?`, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "20990328 08:00", stored.StartsAt)
+
+	// Summer time starts in Berlin on March 29, 2099, and the meeting stays
+	// at 9 o'clock local time.
+	ret, err = postJSON("api/task", map[string]any{
+		"id":       id,
+		"date":     "20990329",
+		"time":     "09:00",
+		"title":    "Утренняя встреча",
+		"repeat":   "d 1",
+		"timezone": "Europe/Berlin",
+	}, http.MethodPut)
+	assert.NoError(t, err)
+	assert.Empty(t, ret)
+
+	err = db.Get(&stored, `SELECT * FROM scheduler WHERE id=?`, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "09:00", stored.Time)
+	assert.Equal(t, "20990329 07:00", stored.StartsAt)
+
+	_, err = db.Exec("DELETE FROM scheduler WHERE id = ?", id)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPITokens(t *testing.T) {
+	// Tokens only matter once there is a user, see TestUsers.
+	if len(Token) > 0 {
+		return
+	}
+	db := openDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("DELETE FROM scheduler")
+	assert.NoError(t, err)
+	defer func() {
+		for _, query := range []string{"DELETE FROM users", "DELETE FROM lists", "DELETE FROM api_tokens", "UPDATE scheduler SET owner_id = 0"} {
+			_, err := db.Exec(query)
+			assert.NoError(t, err)
+		}
+	}()
+
+	status, m := requestAs(t, "", http.MethodPost, "api/register", map[string]any{"login": "home", "password": "home's password"})
+	assert.Equal(t, http.StatusOK, status, "%v", m)
+	session, _ := m["token"].(string)
+
+	tokens := map[string]string{}
+	for _, scope := range []string{"read", "write", "admin"} {
+		status, m = requestAs(t, session, http.MethodPost, "api/tokens", map[string]any{"name": "Скрипт " + scope, "scope": scope})
+		assert.Equal(t, http.StatusOK, status, "%v", m)
+		assert.Equal(t, scope, m["scope"])
+		assert.NotEmpty(t, m["id"])
+		token, _ := m["token"].(string)
+		assert.Regexp(t, `^todo_`, token)
+		tokens[scope] = "Bearer " + token
+	}
+
+	for _, token := range []map[string]any{{"name": ""}, {"name": "Умный дом", "scope": "root"}} {
+		_, m = requestAs(t, session, http.MethodPost, "api/tokens", token)
+		assert.NotEmpty(t, m["error"], "%v", token)
+	}
+
+	// Only hashes are kept.
+	var stored int
+	err = db.Get(&stored, "SELECT count(*) FROM api_tokens WHERE hash LIKE 'todo_%' OR prefix = hash")
+	assert.NoError(t, err)
+	assert.Zero(t, stored)
+
+	date := time.Now().Format(`20060102`)
+	task := map[string]any{"date": date, "title": "Полить цветы"}
+
+	status, _ = requestAs(t, tokens["read"], http.MethodGet, "api/tasks", nil)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = requestAs(t, tokens["read"], http.MethodPost, "api/task", task)
+	assert.Equal(t, http.StatusForbidden, status)
+	status, m = requestAs(t, tokens["write"], http.MethodPost, "api/task", task)
+	assert.Equal(t, http.StatusOK, status)
+	assert.NotEmpty(t, m["id"])
+	status, _ = requestAs(t, tokens["write"], http.MethodGet, "api/tokens", nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, m = requestAs(t, tokens["admin"], http.MethodGet, "api/tokens", nil)
+	assert.Equal(t, http.StatusOK, status)
+	list, _ := m["tokens"].([]any)
+	assert.Len(t, list, 3)
+	for _, item := range list {
+		token := item.(map[string]any)
+		assert.Empty(t, token["token"])
+		assert.NotEmpty(t, token["prefix"])
+		if token["scope"] != "read" {
+			continue
+		}
+
+		assert.NotEmpty(t, token["used_at"])
+		status, m = requestAs(t, session, http.MethodDelete, fmt.Sprintf("api/tokens/%v", token["id"]), nil)
+		assert.Equal(t, http.StatusOK, status, "%v", m)
+	}
+
+	// A revoked token stops working at once.
+	status, _ = requestAs(t, tokens["read"], http.MethodGet, "api/tasks", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = requestAs(t, tokens["write"], http.MethodGet, "api/tasks", nil)
+	assert.Equal(t, http.StatusOK, status)
+
+	// Tokens belong to their user.
+	status, m = requestAs(t, session, http.MethodPost, "api/register", map[string]any{"login": "guest", "password": "guest's password"})
+	assert.Equal(t, http.StatusOK, status, "%v", m)
+	guest, _ := m["token"].(string)
+	_, m = requestAs(t, guest, http.MethodGet, "api/tokens", nil)
+	assert.Empty(t, m["tokens"])
+	_, m = requestAs(t, guest, http.MethodGet, "api/tasks", nil)
+	assert.Empty(t, m["tasks"])
+}
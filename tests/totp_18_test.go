@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// totpCode is the 6-digit code of a base32 secret at a time, as in RFC 6238.
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	assert.NoError(t, err)
+
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(at.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff%1000000)
+}
+
+func TestTOTP(t *testing.T) {
+	// Two-factor authentication is tried on a user of its own, see
+	// TestUsers.
+	if len(Token) > 0 {
+		return
+	}
+	db := openDB(t)
+	defer db.Close()
+
+	defer func() {
+		for _, query := range []string{"DELETE FROM users", "DELETE FROM lists", "DELETE FROM totp", "DELETE FROM recovery_codes", "UPDATE scheduler SET owner_id = 0"} {
+			_, err := db.Exec(query)
+			assert.NoError(t, err)
+		}
+	}()
+
+	credentials := map[string]any{"login": "anna", "password": "anna's password"}
+	status, m := requestAs(t, "", http.MethodPost, "api/register", credentials)
+	assert.Equal(t, http.StatusOK, status, "%v", m)
+	session, _ := m["token"].(string)
+
+	status, m = requestAs(t, session, http.MethodPost, "api/totp", nil)
+	assert.Equal(t, http.StatusOK, status, "%v", m)
+	secret, _ := m["secret"].(string)
+	assert.NotEmpty(t, secret)
+	assert.Regexp(t, `^otpauth://totp/todo-list:anna\?`, m["uri"])
+	assert.Contains(t, m["uri"], "secret="+secret)
+
+	// Until it is confirmed, nothing changes.
+	_, m = requestAs(t, session, http.MethodGet, "api/totp", nil)
+	assert.Equal(t, false, m["enabled"])
+	status, _ = requestAs(t, "", http.MethodPost, "api/signin", credentials)
+	assert.Equal(t, http.StatusOK, status)
+
+	now := time.Now()
+	_, m = requestAs(t, session, http.MethodPost, "api/totp/confirm", map[string]any{"code": totpCode(t, secret, now.Add(-time.Hour))})
+	assert.NotEmpty(t, m["error"])
+	status, m = requestAs(t, session, http.MethodPost, "api/totp/confirm", map[string]any{"code": totpCode(t, secret, now)})
+	assert.Equal(t, http.StatusOK, status, "%v", m)
+	assert.Equal(t, true, m["enabled"])
+	recovery, _ := m["recovery_codes"].([]any)
+	assert.Len(t, recovery, 10)
+
+	_, m = requestAs(t, session, http.MethodPost, "api/totp", nil)
+	assert.NotEmpty(t, m["error"], "enrolled twice")
+
+	// Only hashes of the recovery codes are kept.
+	var stored int
+	err := db.Get(&stored, "SELECT count(*) FROM recovery_codes WHERE hash = :code", recovery[0])
+	assert.NoError(t, err)
+	assert.Zero(t, stored)
+
+	// Signing in fails closed without the second factor.
+	for _, code := range []any{nil, "", "000000", totpCode(t, secret, now), "0000-0000-0000-0000"} {
+		values := map[string]any{"login": "anna", "password": "anna's password"}
+		if code != nil {
+			values["code"] = code
+		}
+
+		status, m = requestAs(t, "", http.MethodPost, "api/signin", values)
+		assert.Equal(t, http.StatusBadRequest, status, "%v", code)
+		assert.Empty(t, m["token"], "%v", code)
+	}
+
+	// Nor does the second factor stand in for the password.
+	status, _ = requestAs(t, "", http.MethodPost, "api/signin", map[string]any{"login": "anna", "password": "wrong", "code": totpCode(t, secret, now.Add(30*time.Second))})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, m = requestAs(t, "", http.MethodPost, "api/signin", map[string]any{"login": "anna", "password": "anna's password", "code": totpCode(t, secret, now.Add(30*time.Second))})
+	assert.Equal(t, http.StatusOK, status, "%v", m)
+	assert.NotEmpty(t, m["token"])
+
+	// A recovery code works once, typed in any case.
+	code := strings.ToUpper(recovery[1].(string))
+	for i, want := range []int{http.StatusOK, http.StatusBadRequest} {
+		status, m = requestAs(t, "", http.MethodPost, "api/signin", map[string]any{"login": "anna", "password": "anna's password", "code": code})
+		assert.Equal(t, want, status, "attempt %d: %v", i, m)
+	}
+
+	_, m = requestAs(t, session, http.MethodGet, "api/totp", nil)
+	assert.Equal(t, true, m["enabled"])
+	assert.Equal(t, float64(9), m["recovery_codes_left"])
+
+	status, m = requestAs(t, session, http.MethodPost, "api/totp/recovery", map[string]any{"code": recovery[2]})
+	assert.Equal(t, http.StatusOK, status, "%v", m)
+	assert.Len(t, m["recovery_codes"], 10)
+	status, _ = requestAs(t, "", http.MethodPost, "api/signin", map[string]any{"login": "anna", "password": "anna's password", "code": recovery[3]})
+	assert.Equal(t, http.StatusBadRequest, status, "an old recovery code still works")
+	fresh, _ := m["recovery_codes"].([]any)
+
+	// Wrong codes tried in parallel don't get past the limit, which locks
+	// TOTP codes out until a recovery code is used.
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			requestAs(t, "", http.MethodPost, "api/signin", map[string]any{"login": "anna", "password": "anna's password", "code": "000000"})
+		}()
+	}
+	wg.Wait()
+
+	var failures int
+	err = db.Get(&failures, "SELECT failures FROM totp")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, failures)
+
+	_, m = requestAs(t, "", http.MethodPost, "api/signin", map[string]any{"login": "anna", "password": "anna's password", "code": totpCode(t, secret, time.Now())})
+	assert.Contains(t, m["error"], "too many")
+
+	status, _ = requestAs(t, "", http.MethodPost, "api/signin", map[string]any{"login": "anna", "password": "anna's password", "code": fresh[1]})
+	assert.Equal(t, http.StatusOK, status)
+	err = db.Get(&failures, "SELECT failures FROM totp")
+	assert.NoError(t, err)
+	assert.Zero(t, failures)
+
+	_, m = requestAs(t, session, http.MethodDelete, "api/totp", map[string]any{})
+	assert.NotEmpty(t, m["error"], "turned off without a code")
+	status, m = requestAs(t, session, http.MethodDelete, "api/totp", map[string]any{"code": fresh[0]})
+	assert.Equal(t, http.StatusOK, status, "%v", m)
+
+	status, _ = requestAs(t, "", http.MethodPost, "api/signin", credentials)
+	assert.Equal(t, http.StatusOK, status)
+}
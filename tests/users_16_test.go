@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// requestAs sends a request with the token of a user in the token cookie,
+// with an "Authorization: Bearer ..." header when it starts with "Bearer ",
+// or with none.
+func requestAs(t *testing.T, token, method, path string, values map[string]any) (int, map[string]any) {
+	var data []byte
+	if values != nil {
+		var err error
+		data, err = json.Marshal(values)
+		assert.NoError(t, err)
+	}
+
+	req, err := http.NewRequest(method, getURL(path), bytes.NewBuffer(data))
+	assert.NoError(t, err)
+	if strings.HasPrefix(token, "Bearer ") {
+		req.Header.Set("Authorization", token)
+	} else if token != "" {
+		req.AddCookie(&http.Cookie{Name: "token", Value: token})
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	var m map[string]any
+	if len(bytes.TrimSpace(body)) > 0 {
+		assert.NoError(t, json.Unmarshal(body, &m), string(body))
+	}
+	return resp.StatusCode, m
+}
+
+func TestUsers(t *testing.T) {
+	// Registering the first user turns authentication on, which only
+	// happens when it is off to begin with.
+	if len(Token) > 0 {
+		return
+	}
+	db := openDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("DELETE FROM scheduler")
+	assert.NoError(t, err)
+	defer func() {
+		for _, query := range []string{"DELETE FROM users", "DELETE FROM lists", "UPDATE scheduler SET owner_id = 0"} {
+			_, err := db.Exec(query)
+			assert.NoError(t, err)
+		}
+	}()
+
+	date := time.Now().Format(`20060102`)
+	addTask(t, task{date: date, title: "Задача до регистрации"})
+
+	for _, user := range []map[string]any{
+		{"login": "a", "password": "password1"},
+		{"login": "anna smith", "password": "password1"},
+		{"login": "anna", "password": "short"},
+	} {
+		status, m := requestAs(t, "", http.MethodPost, "api/register", user)
+		assert.Equal(t, http.StatusBadRequest, status, "%v", user)
+		assert.NotEmpty(t, m["error"])
+	}
+
+	status, m := requestAs(t, "", http.MethodPost, "api/register", map[string]any{"login": "Anna", "password": "anna's password"})
+	assert.Equal(t, http.StatusOK, status, "%v", m)
+	anna, _ := m["token"].(string)
+	assert.NotEmpty(t, anna)
+
+	// Now that there is a user, the API is closed to everyone else.
+	status, _ = requestAs(t, "", http.MethodGet, "api/tasks", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = requestAs(t, "", http.MethodPost, "api/register", map[string]any{"login": "boris", "password": "boris's password"})
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// The first user takes over the tasks from before.
+	status, m = requestAs(t, anna, http.MethodGet, "api/tasks", nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, m["tasks"], 1)
+
+	status, m = requestAs(t, anna, http.MethodPost, "api/register", map[string]any{"login": "boris", "password": "boris's password"})
+	assert.Equal(t, http.StatusOK, status, "%v", m)
+	status, m = requestAs(t, anna, http.MethodPost, "api/register", map[string]any{"login": "BORIS", "password": "boris's password"})
+	assert.Equal(t, http.StatusBadRequest, status, "%v", m)
+
+	status, m = requestAs(t, "", http.MethodPost, "api/signin", map[string]any{"login": "boris", "password": "anna's password"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.NotEmpty(t, m["error"])
+	status, m = requestAs(t, "", http.MethodPost, "api/signin", map[string]any{"login": "boris", "password": "boris's password"})
+	assert.Equal(t, http.StatusOK, status)
+	boris, _ := m["token"].(string)
+	assert.NotEmpty(t, boris)
+
+	status, m = requestAs(t, boris, http.MethodPost, "api/task", map[string]any{"date": date, "title": "Задача Бориса"})
+	assert.Equal(t, http.StatusOK, status)
+	id := fmt.Sprint(m["id"])
+
+	status, m = requestAs(t, boris, http.MethodGet, "api/tasks", nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, m["tasks"], 1)
+
+	// Anna can't see, change, complete or delete the task of Boris.
+	_, m = requestAs(t, anna, http.MethodGet, "api/task?id="+id, nil)
+	assert.NotEmpty(t, m["error"])
+	_, m = requestAs(t, anna, http.MethodPut, "api/task", map[string]any{"id": id, "date": date, "title": "Чужая задача"})
+	assert.NotEmpty(t, m["error"])
+	_, m = requestAs(t, anna, http.MethodPost, "api/task/done?id="+id, nil)
+	assert.NotEmpty(t, m["error"])
+	requestAs(t, anna, http.MethodDelete, "api/task?id="+id, nil)
+	_, m = requestAs(t, anna, http.MethodGet, "api/agenda", nil)
+	assert.Len(t, m["today"], 1)
+
+	var task Task
+	err = db.Get(&task, `SELECT * FROM scheduler WHERE id=?`, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Задача Бориса", task.Title)
+
+	// Lists are per user too.
+	status, _ = requestAs(t, anna, http.MethodPost, "api/lists", map[string]any{"name": "Мои", "filter": "repeat:none"})
+	assert.Equal(t, http.StatusOK, status)
+	_, m = requestAs(t, boris, http.MethodGet, "api/lists/Мои/tasks", nil)
+	assert.NotEmpty(t, m["error"])
+	_, m = requestAs(t, anna, http.MethodGet, "api/lists/Мои/tasks", nil)
+	assert.Len(t, m["tasks"], 1)
+}